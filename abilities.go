@@ -0,0 +1,211 @@
+package yasha
+
+import "time"
+
+// AbilityState is what's known about an ability of a hero at the tick being
+// parsed.
+type AbilityState struct {
+	Handle         int
+	Hero           int // handle of the hero
+	Name           string
+	Level          int
+	Cooldown       float64 // server time when it's ready again, see Remaining
+	CooldownLength float64 // seconds
+	ManaCost       int
+	Charges        int
+}
+
+// Remaining returns how long the ability is still on cooldown at the server
+// time now, like the m_fGameTime prop.
+func (a *AbilityState) Remaining(now float64) time.Duration {
+	if a.Cooldown <= now {
+		return 0
+	}
+	return time.Duration((a.Cooldown - now) * float64(time.Second))
+}
+
+// AbilityCast is a use of an ability, see Parser.OnAbilityCast. Targets are
+// from the CombatLogAbility entries of the same tick, so empty for abilities
+// that don't log any.
+type AbilityCast struct {
+	Tick     int
+	GameTime time.Duration // only valid if the game clock was Started
+	Hero     int
+	Ability  string
+	Level    int
+	ManaCost int
+	Targets  []string
+}
+
+// AbilityBook follows the abilities of all heroes picked by players, see
+// Parser.TrackAbilities.
+type AbilityBook struct {
+	Build Abilities // level ups of all heroes, in order
+	Casts []*AbilityCast
+
+	abilities map[int]*AbilityState
+	heroes    map[int][]int // ability handles of every hero, by slot
+	casts     []*AbilityState
+	logs      []*CombatLogAbility
+}
+
+// TrackAbilities makes Parse follow the m_hAbilities of the heroes of all
+// players, calling OnAbilityLevelUp and OnAbilityCast.
+//
+// A cast is seen when the cooldown of an ability starts, and matched with
+// the CombatLogAbility entries of the hero and ability in the same tick.
+// Entries without a cooldown starting, like from abilities without one, are
+// casts too. Abilities first seen mid-game start at the level they have then.
+func (p *Parser) TrackAbilities() *AbilityBook {
+	p.abilities = &AbilityBook{
+		abilities: map[int]*AbilityState{},
+		heroes:    map[int][]int{},
+	}
+	return p.abilities
+}
+
+// Hero returns the current abilities of a hero, by slot. Empty slots are nil.
+func (b *AbilityBook) Hero(hero int) []*AbilityState {
+	handles := b.heroes[hero]
+	abilities := make([]*AbilityState, len(handles))
+	for i, handle := range handles {
+		abilities[i] = b.abilities[handle]
+	}
+	return abilities
+}
+
+// SkillBuild returns the level ups of a hero, in order.
+func (b *AbilityBook) SkillBuild(hero int) Abilities {
+	var build Abilities
+	for _, ability := range b.Build {
+		if ability.HeroHandle == hero {
+			build = append(build, ability)
+		}
+	}
+	return build
+}
+
+func (b *AbilityBook) update(p *Parser, tick int) error {
+	defer func() {
+		b.casts = b.casts[:0]
+		b.logs = b.logs[:0]
+	}()
+
+	gameTime, _ := p.Clock().TickToGameTime(tick)
+	for _, player := range p.Match().Players {
+		if hero := p.hero(player.Slot); hero != nil {
+			if err := b.updateHero(p, tick, gameTime, hero); err != nil {
+				return err
+			}
+		}
+	}
+
+	for _, state := range b.casts {
+		if err := b.cast(p, tick, gameTime, state); err != nil {
+			return err
+		}
+	}
+	// left over entries are casts without a cooldown.
+	for _, log := range b.logs {
+		if log == nil {
+			continue
+		}
+		hero := p.heroByName(log.Attacker)
+		if hero == nil {
+			continue
+		}
+		for _, handle := range b.heroes[hero.Handle()] {
+			if state := b.abilities[handle]; state != nil && state.Name == log.Ability {
+				if err := b.cast(p, tick, gameTime, state); err != nil {
+					return err
+				}
+				break
+			}
+		}
+	}
+	return nil
+}
+
+func (b *AbilityBook) updateHero(p *Parser, tick int, gameTime time.Duration, hero *PacketEntity) error {
+	keys := p.arrayKeys(hero.ClassId, "m_hAbilities")
+	handles := b.heroes[hero.Handle()]
+	if len(handles) != len(keys) {
+		handles = make([]int, len(keys))
+		b.heroes[hero.Handle()] = handles
+	}
+
+	for i, key := range keys {
+		handle, _ := toInt(hero.Values[key])
+		ability := p.ByHandle[handle]
+		if ability == nil {
+			handles[i] = 0
+			continue
+		}
+		handles[i] = handle
+
+		state := b.abilities[handle]
+		cooldown, _ := ability.GetFloat("m_fCooldown")
+		level, _ := ability.GetInt("m_iLevel")
+		if state == nil {
+			// levels from before the ability was first seen aren't level ups.
+			state = &AbilityState{Handle: handle, Hero: hero.Handle(), Name: p.entityName(ability), Level: level, Cooldown: cooldown}
+			b.abilities[handle] = state
+		}
+		if cooldown > state.Cooldown {
+			b.casts = append(b.casts, state)
+		}
+		state.Cooldown = cooldown
+		state.CooldownLength, _ = ability.GetFloat("m_flCooldownLength")
+		state.ManaCost, _ = ability.GetInt("m_iManaCost")
+		state.Charges, _ = ability.GetInt("m_nAbilityCurrentCharges")
+
+		if level < state.Level {
+			state.Level = level
+		}
+		for state.Level < level {
+			state.Level++
+			levelUp := &AbilityTracker{HeroHandle: hero.Handle(), Level: state.Level, Tick: tick, Name: state.Name, GameTime: gameTime}
+			b.Build = append(b.Build, levelUp)
+			if p.OnAbilityLevelUp != nil {
+				if err := p.OnAbilityLevelUp(levelUp); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// cast records a cast of state with the targets of the matching log entries,
+// which are taken out of b.logs.
+func (b *AbilityBook) cast(p *Parser, tick int, gameTime time.Duration, state *AbilityState) error {
+	cast := &AbilityCast{
+		Tick:     tick,
+		GameTime: gameTime,
+		Hero:     state.Hero,
+		Ability:  state.Name,
+		Level:    state.Level,
+		ManaCost: state.ManaCost,
+	}
+	name := b.heroName(p, state.Hero)
+	for i, log := range b.logs {
+		if log != nil && log.Ability == state.Name && log.Attacker == name {
+			cast.Targets = append(cast.Targets, log.Target)
+			b.logs[i] = nil
+		}
+	}
+	b.Casts = append(b.Casts, cast)
+	if p.OnAbilityCast != nil {
+		return p.OnAbilityCast(cast)
+	}
+	return nil
+}
+
+// heroName returns the unit name of a hero, as used by the combat log.
+func (b *AbilityBook) heroName(p *Parser, hero int) string {
+	if entity := p.ByHandle[hero]; entity != nil {
+		name, _ := entity.GetString("m_iszUnitName")
+		return name
+	}
+	return ""
+}
@@ -0,0 +1,155 @@
+package yasha
+
+import (
+	"context"
+	"fmt"
+	"runtime"
+	"sync"
+)
+
+// BatchResult is the outcome of parsing one replay of a batch.
+type BatchResult struct {
+	Index int // of the replay in the paths passed to Run
+	Path  string
+	Value interface{} // returned by Setup
+	Err   error
+}
+
+// BatchParser parses many replays concurrently. Every replay gets its own
+// Parser, so no parser state is shared between the workers, only whatever the
+// callbacks registered by Setup share themselves.
+type BatchParser struct {
+	// Workers is the number of replays parsed at the same time, runtime.NumCPU()
+	// if it's 0.
+	Workers int
+
+	// Ordered delivers the results in the order of the paths, instead of as soon
+	// as each replay is done.
+	Ordered bool
+
+	// Open creates the Parser for a path, ParserFromFile by default.
+	Open func(path string) (*Parser, error)
+
+	// Setup registers the callbacks for a replay before it's parsed. It's called
+	// from the worker goroutines, and its return value, like a pointer to a
+	// struct the callbacks fill in, becomes the Value of the result.
+	Setup func(path string, parser *Parser) interface{}
+
+	// OnProgress is called whenever a replay is done, with the number of replays
+	// done so far. Calls don't overlap.
+	OnProgress func(done, total int, result BatchResult)
+}
+
+// ParseAll parses paths with a BatchParser and returns all results in the
+// order of paths.
+func ParseAll(ctx context.Context, paths []string, workers int, setup func(path string, parser *Parser) interface{}) []BatchResult {
+	b := &BatchParser{Workers: workers, Ordered: true, Setup: setup}
+	results := make([]BatchResult, 0, len(paths))
+	for result := range b.Run(ctx, paths) {
+		results = append(results, result)
+	}
+	return results
+}
+
+// Run starts parsing paths and returns a channel with one result for each of
+// them, which is closed at the end and has to be drained. A failing or
+// panicking replay only fails its own result. Once ctx is done, running
+// replays stop at their next tick and the ones not started yet fail with
+// ctx.Err().
+func (b *BatchParser) Run(ctx context.Context, paths []string) <-chan BatchResult {
+	workers := b.Workers
+	if workers <= 0 {
+		workers = runtime.NumCPU()
+	}
+
+	jobs := make(chan int)
+	go func() {
+		defer close(jobs)
+		for i := range paths {
+			jobs <- i
+		}
+	}()
+
+	done := make(chan BatchResult)
+	var wg sync.WaitGroup
+	wg.Add(workers)
+	for w := 0; w < workers; w++ {
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				done <- b.parse(ctx, i, paths[i])
+			}
+		}()
+	}
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+
+	results := make(chan BatchResult)
+	go b.deliver(len(paths), done, results)
+	return results
+}
+
+func (b *BatchParser) deliver(total int, done <-chan BatchResult, results chan<- BatchResult) {
+	defer close(results)
+
+	count, next := 0, 0
+	waiting := map[int]BatchResult{}
+	for result := range done {
+		count++
+		if b.OnProgress != nil {
+			b.OnProgress(count, total, result)
+		}
+		if !b.Ordered {
+			results <- result
+			continue
+		}
+		waiting[result.Index] = result
+		for {
+			result, ok := waiting[next]
+			if !ok {
+				break
+			}
+			delete(waiting, next)
+			results <- result
+			next++
+		}
+	}
+}
+
+// parse runs one replay, turning a panic anywhere in it into an ErrPanic.
+func (b *BatchParser) parse(ctx context.Context, index int, path string) (result BatchResult) {
+	result = BatchResult{Index: index, Path: path}
+	if err := ctx.Err(); err != nil {
+		result.Err = err
+		return result
+	}
+
+	var parser *Parser
+	defer func() {
+		if r := recover(); r != nil {
+			perr := &ParseError{Err: ErrPanic, Cause: fmt.Errorf("%v", r)}
+			if parser != nil {
+				perr.Tick, perr.Offset = parser.tick, parser.Parser.offset
+			}
+			result.Err = perr
+		}
+	}()
+
+	open := b.Open
+	if open == nil {
+		open = ParserFromFile
+	}
+	parser, err := open(path)
+	if err != nil {
+		result.Err = err
+		return result
+	}
+	defer parser.Close()
+	if b.Setup != nil {
+		result.Value = b.Setup(path, parser)
+	}
+	result.Err = parser.ParseContext(ctx)
+	return result
+}
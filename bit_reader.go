@@ -5,7 +5,6 @@ import (
 	"encoding/binary"
 	"fmt"
 	"math"
-	"strconv"
 
 	"github.com/davecgh/go-spew/spew"
 )
@@ -303,7 +302,7 @@ func (br *BitReader) ReadVector(prop *SendProp) *Vector3 {
 		z = br.ReadFloat(prop)
 	} else {
 		f := float64(x*x + y*y)
-		if 1.0 >= f {
+		if f >= 1.0 {
 			z = 0
 		} else {
 			z = float64(math.Sqrt(1.0 - f))
@@ -362,7 +361,11 @@ func (br *BitReader) ReadNextEntityIndex(oldEntity int) int {
 }
 
 func (br *BitReader) ReadPropertiesIndex() []int {
-	props := []int{}
+	return br.readPropertiesIndex([]int{})
+}
+
+// readPropertiesIndex appends the indices to props, to reuse its memory.
+func (br *BitReader) readPropertiesIndex(props []int) []int {
 	prop := -1
 	for {
 		if br.ReadBoolean() {
@@ -381,51 +384,52 @@ func (br *BitReader) ReadPropertiesIndex() []int {
 	return props
 }
 
-// FIXME:
-// Rememver that our PE decoder is wrong, it needs to construct key names recursively.
-// https://github.com/spheenik/clarity/tree/master/src/main/java/clarity/decoder/SendTableFlattener.java
-// https://gist.githubusercontent.com/onethirtyfive/07899a78622dc18679c3/raw/19d411910016170e4c4ee2782fd4a987e9ce2afc/gistfile1.txt
-func (br *BitReader) ReadPropertiesValues(mapping []*SendProp, multiples map[string]int, indices []int) map[string]interface{} {
+func (br *BitReader) ReadPropertiesValues(mapping []*SendProp, indices []int) map[string]interface{} {
 	values := map[string]interface{}{}
 
 	for _, index := range indices {
 		prop := mapping[index]
-		name := prop.DtName + "." + prop.VarName
-		multiple := multiples[name] > 1
-		elements := 1
-		if prop.Flags&SPROP_INSIDEARRAY != 0 {
-			elements = int(br.ReadUBits(6))
-		}
-		for k := 0; k < elements; k++ {
-			key := name
-			if multiple {
-				key += "-" + strconv.Itoa(index)
-			}
-			if elements > 1 {
-				key += "-" + strconv.Itoa(k)
-			}
-			switch prop.Type {
-			case DPT_Int:
-				values[key] = br.decodeInt(prop)
-			case DPT_Int64:
-				values[key] = br.decodeInt64(prop)
-			case DPT_Float:
-				values[key] = br.ReadFloat(prop)
-			case DPT_Vector:
-				values[key] = br.ReadVector(prop)
-			case DPT_VectorXY:
-				values[key] = br.ReadVectorXY(prop)
-			case DPT_String:
-				values[key] = br.decodeString()
-			default:
-				panic("unknown type")
-			}
-		}
+		values[prop.Name] = br.decodeValue(prop)
 	}
 
 	return values
 }
 
+func (br *BitReader) decodeValue(prop *SendProp) interface{} {
+	switch prop.Type {
+	case DPT_Int:
+		return br.decodeInt(prop)
+	case DPT_Int64:
+		return br.decodeInt64(prop)
+	case DPT_Float:
+		return br.ReadFloat(prop)
+	case DPT_Vector:
+		return br.ReadVector(prop)
+	case DPT_VectorXY:
+		return br.ReadVectorXY(prop)
+	case DPT_String:
+		return br.decodeString()
+	case DPT_Array:
+		return br.decodeArray(prop)
+	}
+	panic(fmt.Errorf("unknown type %d of %s", prop.Type, prop.Name))
+}
+
+// decodeArray reads the number of elements in as many bits as needed for
+// NumElements, followed by the elements.
+func (br *BitReader) decodeArray(prop *SendProp) []interface{} {
+	bits := 0
+	for n := prop.NumElements; n > 0; n >>= 1 {
+		bits++
+	}
+	count := int(br.read(bits))
+	elements := make([]interface{}, count)
+	for i := range elements {
+		elements[i] = br.decodeValue(prop.Element)
+	}
+	return elements
+}
+
 func (br *BitReader) decodeInt(prop *SendProp) interface{} {
 	if (prop.Flags & SPROP_ENCODED_AGAINST_TICKCOUNT) != 0 {
 		val := br.decodeVarInt()
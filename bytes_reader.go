@@ -42,6 +42,11 @@ func (br *BytesReader) Read(length int) []byte {
 func (br BytesReader) CanRead() bool {
 	return br.position < len(br.data)
 }
+
+func (br BytesReader) Remaining() int {
+	return len(br.data) - br.position
+}
+
 func (br *BytesReader) Skip(length int) {
 	br.position += length
 }
@@ -20,6 +20,7 @@ type CombatLogEntry interface {
 type combatLogParser struct {
 	stsh     *StateHelper
 	distinct map[dota.DOTA_COMBATLOG_TYPES][]map[interface{}]bool
+	logger   Logger
 }
 
 /*
@@ -39,7 +40,7 @@ The default is mostly:
 12 AttackerIsHero
 13 TargetIsHero
 */
-func (c combatLogParser) parse(obj *dota.CSVCMsg_GameEvent) CombatLogEntry {
+func (c combatLogParser) parse(tick int, obj *dota.CSVCMsg_GameEvent) (CombatLogEntry, error) {
 	keys := obj.GetKeys()
 
 	var v CombatLogEntry
@@ -84,17 +85,19 @@ func (c combatLogParser) parse(obj *dota.CSVCMsg_GameEvent) CombatLogEntry {
 		v = &CombatLogMultikill{}
 	case dota.DOTA_COMBATLOG_TYPES_DOTA_COMBATLOG_FIRST_BLOOD:
 		// TODO: map DOTA_COMBATLOG_TYPES_DOTA_COMBATLOG_FIRST_BLOOD
-		return nil
+		return nil, nil
 	case dota.DOTA_COMBATLOG_TYPES_DOTA_COMBATLOG_MODIFIER_REFRESH:
 		// TODO: map DOTA_COMBATLOG_TYPES_DOTA_COMBATLOG_MODIFIER_REFRESH
-		return nil
+		return nil, nil
 	default:
-		pp(t, keys)
-		return nil
+		logTo(c.logger, SeverityDebug, tick, "dota_combatlog", "unknown combat log type %s", t)
+		return nil, nil
 	}
 
-	c.assign(v, keys)
-	return v
+	if err := c.assign(tick, v, keys); err != nil {
+		return nil, err
+	}
+	return v, nil
 }
 
 type CombatLogBuyback struct {
@@ -515,7 +518,7 @@ func (c combatLogParser) logDistinct(t dota.DOTA_COMBATLOG_TYPES, keys []*dota.C
 	}
 }
 
-func (c combatLogParser) assign(v CombatLogEntry, keys []*dota.CSVCMsg_GameEventKeyT) {
+func (c combatLogParser) assign(tick int, v CombatLogEntry, keys []*dota.CSVCMsg_GameEventKeyT) error {
 	rv := reflect.ValueOf(v).Elem()
 	rt := rv.Type()
 	fieldIndices := make([]int, rv.NumField())
@@ -538,10 +541,13 @@ func (c combatLogParser) assign(v CombatLogEntry, keys []*dota.CSVCMsg_GameEvent
 		case 4:
 			valShort := key.GetValShort()
 			if logTable := fieldTag.Get("logTable"); logTable != "" {
-				table := c.stsh.GetTableNow(logTable).Items
-				entry := table[int(valShort)]
+				table := c.stsh.GetTableNow(logTable)
+				if table == nil {
+					return &ParseError{Err: ErrUnknownTable, Cause: Error(logTable)}
+				}
+				entry := table.Items[int(valShort)]
 				if entry == nil {
-					spew.Printf("no entry %d in %s for %v\n", valShort, logTable, v)
+					logTo(c.logger, SeverityWarning, tick, "dota_combatlog", "no entry %d in %s for %s", valShort, logTable, v.Type())
 				} else {
 					field.SetString(entry.Str)
 				}
@@ -555,9 +561,10 @@ func (c combatLogParser) assign(v CombatLogEntry, keys []*dota.CSVCMsg_GameEvent
 		case 6:
 			field.SetBool(key.GetValBool())
 		default:
-			panic("unknown GameEventKey Type" + spew.Sdump(key) + " in " + spew.Sdump(keys))
+			return &ParseError{Err: ErrDecode, Cause: fmt.Errorf("unknown GameEventKey type %d for %T", key.GetType(), v)}
 		}
 	}
+	return nil
 }
 
 func printCombatLogKeys(v CombatLogEntry, keys []*dota.CSVCMsg_GameEventKeyT) {
@@ -1,14 +1,20 @@
 package yasha
 
-import "github.com/davecgh/go-spew/spew"
+import (
+	"time"
+
+	"github.com/davecgh/go-spew/spew"
+)
 
 var pp = spew.Dump
 
+// AbilityTracker is one point of a skill build, see Parser.TrackAbilities.
 type AbilityTracker struct {
 	HeroHandle int
 	Level      int
 	Tick       int
 	Name       string
+	GameTime   time.Duration // only valid if the game clock was Started
 }
 
 type Abilities []*AbilityTracker
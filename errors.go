@@ -0,0 +1,61 @@
+package yasha
+
+import "fmt"
+
+const (
+	ErrBadMagic     = Error("demofilestamp doesn't match")
+	ErrTruncated    = Error("truncated frame")
+	ErrBadCommand   = Error("invalid demo command")
+	ErrSnappy       = Error("snappy decompression failed")
+	ErrProtoDecode  = Error("protobuf decoding failed")
+	ErrUnknownTable = Error("unknown string table")
+	ErrStringTable  = Error("invalid string table")
+	ErrDecode       = Error("malformed data")
+	ErrNotSeekable  = Error("replay is not seekable")
+	ErrBadHandler   = Error("handler must be a func(int, proto.Message) error")
+	ErrPanic        = Error("parser panicked")
+	ErrNoFileInfo   = Error("replay has no file info")
+
+	// ErrStopParsing can be returned by any callback to end Parse early,
+	// without Parse itself returning an error.
+	ErrStopParsing = Error("stop parsing")
+)
+
+// ParseError is returned for any failure while reading a replay, Err is one of
+// the Err* values above and can be compared with errors.Is.
+type ParseError struct {
+	Err    error
+	Tick   int
+	Offset int // byte offset of the frame in the replay
+	Cause  error
+}
+
+func (e *ParseError) Error() string {
+	msg := fmt.Sprintf("%s at tick %d, offset %d", e.Err, e.Tick, e.Offset)
+	if e.Cause != nil {
+		msg += ": " + e.Cause.Error()
+	}
+	return msg
+}
+
+func (e *ParseError) Unwrap() error { return e.Err }
+
+// locate fills in the position of err, if it's a *ParseError that doesn't know
+// it yet.
+func locate(err error, tick, offset int) error {
+	if perr, ok := err.(*ParseError); ok && perr.Offset == 0 {
+		perr.Tick, perr.Offset = tick, offset
+	}
+	return err
+}
+
+// decodeSafely runs fn and turns panics of the bit-level decoders into a
+// *ParseError, so a corrupt packet doesn't take the whole process down.
+func decodeSafely(tick, offset int, fn func() error) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = &ParseError{Err: ErrDecode, Tick: tick, Offset: offset, Cause: fmt.Errorf("%v", r)}
+		}
+	}()
+	return fn()
+}
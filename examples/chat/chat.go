@@ -15,10 +15,17 @@ func main() {
 	}
 
 	for _, path := range os.Args[1:] {
-		parser := yasha.ParserFromFile(path)
-		parser.OnSayText2 = func(tick int, obj *dota.CUserMsg_SayText2) {
+		parser, err := yasha.ParserFromFile(path)
+		if err != nil {
+			panic(err)
+		}
+		parser.OnSayText2 = func(tick int, obj *dota.CUserMsg_SayText2) error {
 			fmt.Printf("%s - %07d | %s: %s\n", filepath.Base(path), tick, obj.GetPrefix(), obj.GetText())
+			return nil
+		}
+		if err := parser.Parse(); err != nil {
+			panic(err)
 		}
-		parser.Parse()
+		parser.Close()
 	}
 }
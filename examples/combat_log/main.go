@@ -14,20 +14,24 @@ func main() {
 	}
 
 	for _, path := range os.Args[1:] {
-		parser := yasha.ParserFromFile(path)
+		parser, err := yasha.ParserFromFile(path)
+		if err != nil {
+			panic(err)
+		}
 
 		var now time.Duration
 		var gameTime, preGameStarttime float64
 
-		parser.OnEntityPreserved = func(pe *yasha.PacketEntity) {
+		parser.OnEntityPreserved = func(pe *yasha.PacketEntity) error {
 			if pe.Name == "DT_DOTAGamerulesProxy" {
-				gameTime = pe.Values["DT_DOTAGamerules.m_fGameTime"].(float64)
-				preGameStarttime = pe.Values["DT_DOTAGamerules.m_flPreGameStartTime"].(float64)
+				gameTime, _ = pe.GetFloat("m_fGameTime")
+				preGameStarttime, _ = pe.GetFloat("m_flPreGameStartTime")
 				now = time.Duration(gameTime-preGameStarttime) * time.Second
 			}
+			return nil
 		}
 
-		parser.OnCombatLog = func(entry yasha.CombatLogEntry) {
+		parser.OnCombatLog = func(tick int, entry yasha.CombatLogEntry) error {
 			switch log := entry.(type) {
 			case *yasha.CombatLogPurchase:
 				fmt.Printf("%7s | %s bought a %s\n", now, log.Buyer, log.Item)
@@ -40,7 +44,11 @@ func main() {
 			case *yasha.CombatLogHeal:
 				fmt.Printf("%7s | %s heals %s for %dHP\n", now, log.Source, log.Target, log.Value)
 			}
+			return nil
+		}
+		if err := parser.Parse(); err != nil {
+			panic(err)
 		}
-		parser.Parse()
+		parser.Close()
 	}
 }
@@ -8,8 +8,6 @@ import (
 	"github.com/dotabuff/yasha"
 )
 
-const MAX_COORDINATE float64 = 16384
-
 // Example of printing any updates to hero coordinates
 
 func main() {
@@ -18,45 +16,22 @@ func main() {
 	}
 
 	for _, path := range os.Args[1:] {
-		parser := yasha.ParserFromFile(path)
-		parser.OnEntityPreserved = func(pe *yasha.PacketEntity) {
+		parser, err := yasha.ParserFromFile(path)
+		if err != nil {
+			panic(err)
+		}
+		parser.OnEntityPreserved = func(pe *yasha.PacketEntity) error {
 			if strings.HasPrefix(pe.Name, "DT_DOTA_Unit_Hero_") {
-				if _, ok := pe.Delta["DT_DOTA_BaseNPC.m_vecOrigin"]; ok {
-					coord := coordFromCell(pe)
-					fmt.Printf("%30s | X: %5.0f Y: %5.0f\n", pe.Name[18:len(pe.Name)], coord.X, coord.Y)
+				if _, moved := pe.PositionDelta(); moved {
+					pos, _ := pe.Position()
+					fmt.Printf("%30s | X: %5.0f Y: %5.0f\n", pe.Name[18:len(pe.Name)], pos.X, pos.Y)
 				}
 			}
+			return nil
 		}
-		parser.Parse()
-	}
-}
-
-type Coordinate struct {
-	X, Y float64
-}
-
-func coordFromCell(pe *yasha.PacketEntity) Coordinate {
-	cellbits, ok := pe.Values["DT_BaseEntity.m_cellbits"].(int)
-	if !ok {
-		return Coordinate{X: 0, Y: 0}
-	}
-	cellWidth := float64(uint(1) << uint(cellbits))
-
-	var cX, cY, vX, vY float64
-
-	if vO2, ok := pe.Values["DT_DOTA_BaseNPC.m_vecOrigin"].(*yasha.Vector2); ok {
-		cX = float64(pe.Values["DT_DOTA_BaseNPC.m_cellX"].(int))
-		cY = float64(pe.Values["DT_DOTA_BaseNPC.m_cellY"].(int))
-		vX, vY = vO2.X, vO2.Y
-	} else {
-		vO3 := pe.Values["DT_BaseEntity.m_vecOrigin"].(*yasha.Vector3)
-		cX = float64(pe.Values["DT_BaseEntity.m_cellX"].(int))
-		cY = float64(pe.Values["DT_BaseEntity.m_cellY"].(int))
-		vX, vY = vO3.X, vO3.Y
+		if err := parser.Parse(); err != nil {
+			panic(err)
+		}
+		parser.Close()
 	}
-
-	x := ((cX * cellWidth) - MAX_COORDINATE) + vX
-	y := ((cY * cellWidth) - MAX_COORDINATE) + vY
-
-	return Coordinate{X: x, Y: y}
 }
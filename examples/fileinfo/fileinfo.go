@@ -3,6 +3,7 @@ package main
 import (
 	"encoding/json"
 	"os"
+	"strings"
 
 	"github.com/davecgh/go-spew/spew"
 	"github.com/dotabuff/yasha"
@@ -17,14 +18,45 @@ func main() {
 	}
 
 	for _, path := range os.Args[1:] {
-		parser := yasha.ParserFromFile(path)
-		parser.OnFileInfo = func(fileinfo *dota.CDemoFileInfo) {
-			data, err := json.MarshalIndent(fileinfo, "", "  ")
-			if err != nil {
-				panic(err)
-			}
-			spew.Println(string(data))
+		fileinfo, err := readFileInfo(path)
+		if err != nil {
+			panic(err)
 		}
-		parser.Parse()
+		data, err := json.MarshalIndent(fileinfo, "", "  ")
+		if err != nil {
+			panic(err)
+		}
+		spew.Println(string(data))
+	}
+}
+
+// readFileInfo jumps right to the file info of a .dem, but has to parse a
+// .dem.bz2 up to its end.
+func readFileInfo(path string) (*dota.CDemoFileInfo, error) {
+	if strings.HasSuffix(path, ".dem") {
+		fd, err := os.Open(path)
+		if err != nil {
+			return nil, err
+		}
+		defer fd.Close()
+		return yasha.ReadFileInfo(fd)
+	}
+
+	parser, err := yasha.ParserFromFile(path)
+	if err != nil {
+		return nil, err
+	}
+	defer parser.Close()
+	var fileinfo *dota.CDemoFileInfo
+	parser.OnFileInfo = func(obj *dota.CDemoFileInfo) error {
+		fileinfo = obj
+		return yasha.ErrStopParsing
+	}
+	if err := parser.Parse(); err != nil {
+		return nil, err
+	}
+	if fileinfo == nil {
+		return nil, yasha.ErrNoFileInfo
 	}
+	return fileinfo, nil
 }
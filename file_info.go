@@ -0,0 +1,65 @@
+package yasha
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+
+	"github.com/dotabuff/yasha/dota"
+)
+
+// fileInfoBufferSize fits the DEM_FileInfo frame of a usual replay.
+const fileInfoBufferSize = 4096
+
+// ReadFileInfo reads the CDemoFileInfo of a replay, with the playback time and
+// ticks, and the match ID, players, picks and bans and winner in its
+// CGameInfo. It only reads the header and the DEM_FileInfo frame near the end
+// of the replay, which the header points to, instead of parsing everything up
+// to there.
+//
+// r has to be an uncompressed .dem, like an *os.File or a *bytes.Reader.
+// Replays that were cut off before the game ended have no DEM_FileInfo, they
+// return ErrNoFileInfo.
+func ReadFileInfo(r io.ReaderAt) (*dota.CDemoFileInfo, error) {
+	header := make([]byte, headerLength)
+	n, err := r.ReadAt(header, 0)
+	if n < headerLength {
+		if err == nil || err == io.EOF {
+			return nil, &ParseError{Err: ErrTruncated, Offset: n}
+		}
+		return nil, err
+	}
+	if magic := ReadStringZ(header, 0); magic != headerMagic {
+		return nil, &ParseError{Err: ErrBadMagic, Cause: fmt.Errorf("was %q", magic)}
+	}
+
+	offset := int(binary.LittleEndian.Uint32(header[8:]))
+	if offset < headerLength {
+		return nil, ErrNoFileInfo
+	}
+
+	p := &OuterParser{
+		reader:   bufio.NewReaderSize(io.NewSectionReader(r, int64(offset), 1<<62), fileInfoBufferSize),
+		position: offset,
+	}
+	h, err := p.readFrameHeader()
+	if err == io.EOF {
+		return nil, &ParseError{Err: ErrTruncated, Offset: offset}
+	} else if err != nil {
+		return nil, err
+	}
+	if h.command != dota.EDemoCommands_DEM_FileInfo {
+		return nil, &ParseError{Err: ErrBadCommand, Tick: h.tick, Offset: h.offset, Cause: fmt.Errorf("expected DEM_FileInfo, got %s", h.command)}
+	}
+	// readFrameHeader rejects lengths above maxFrameSize.
+	data, err := p.frameData(h, nil)
+	if err != nil {
+		return nil, err
+	}
+	info := &dota.CDemoFileInfo{}
+	if err := ProtoUnmarshal(data, info); err != nil {
+		return nil, &ParseError{Err: ErrProtoDecode, Tick: h.tick, Offset: h.offset, Cause: err}
+	}
+	return info, nil
+}
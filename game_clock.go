@@ -0,0 +1,215 @@
+package yasha
+
+import (
+	"math"
+	"time"
+
+	"github.com/dotabuff/yasha/dota"
+)
+
+// defaultTickInterval is used until the CSVCMsg_ServerInfo is read.
+const defaultTickInterval = 1.0 / 30
+
+// GameClock converts between ticks and the game time shown in game, which is 0
+// at the horn, negative before and stands still while the game is paused. It
+// follows the game rules entity and pause messages as the parser goes along,
+// see Parser.Clock.
+type GameClock struct {
+	TickInterval float64 // seconds, from CSVCMsg_ServerInfo
+	State        dota.DOTA_GameState
+	HornTick     int // only valid once Started
+
+	startTime float64 // m_flGameStartTime, the server time of the horn
+	paused    bool
+	pauses    []pauseSpan
+}
+
+// pauseSpan covers the ticks from start to end, excluding end, which is -1
+// while the game is still paused.
+type pauseSpan struct {
+	start, end int
+}
+
+// Clock returns the game clock, which knows about everything up to the tick
+// being parsed.
+func (p *Parser) Clock() *GameClock {
+	if p.clock == nil {
+		p.clock = &GameClock{TickInterval: defaultTickInterval}
+	}
+	return p.clock
+}
+
+// Started is true once the horn time is known.
+func (c *GameClock) Started() bool {
+	return c.startTime > 0
+}
+
+// Paused reports whether the game was paused at tick.
+func (c *GameClock) Paused(tick int) bool {
+	for _, span := range c.pauses {
+		if tick >= span.start && (tick < span.end || span.end < 0) {
+			return true
+		}
+	}
+	return false
+}
+
+// TickToGameTime returns the game time at tick, ok is false until the game
+// started.
+func (c *GameClock) TickToGameTime(tick int) (time.Duration, bool) {
+	if !c.Started() {
+		return 0, false
+	}
+	ticks := tick - c.HornTick
+	if tick >= c.HornTick {
+		ticks -= c.pausedTicks(c.HornTick, tick)
+	} else {
+		ticks += c.pausedTicks(tick, c.HornTick)
+	}
+	return c.duration(float64(ticks)), true
+}
+
+// GameTimeToTick returns the first tick at which the game time was t, ok is
+// false until the game started, or if t is after a pause that didn't end yet.
+func (c *GameClock) GameTimeToTick(t time.Duration) (int, bool) {
+	if !c.Started() {
+		return 0, false
+	}
+	tick := c.HornTick + int(math.Round(t.Seconds()/c.TickInterval))
+	if t < 0 {
+		return tick, true
+	}
+	for _, span := range c.pauses {
+		if span.start < c.HornTick || span.start >= tick {
+			continue
+		}
+		if span.end < 0 {
+			return 0, false
+		}
+		tick += span.end - span.start
+	}
+	return tick, true
+}
+
+// ServerTimeToGameTime converts a server time, like the m_fGameTime prop or
+// CombatLogEntry times, to game time. ok is false until the game started.
+func (c *GameClock) ServerTimeToGameTime(seconds float64) (time.Duration, bool) {
+	if !c.Started() {
+		return 0, false
+	}
+	return time.Duration((seconds - c.startTime) * float64(time.Second)), true
+}
+
+func (c *GameClock) duration(ticks float64) time.Duration {
+	return time.Duration(ticks * c.TickInterval * float64(time.Second))
+}
+
+// pausedTicks counts the paused ticks from from to to, excluding to.
+func (c *GameClock) pausedTicks(from, to int) int {
+	n := 0
+	for _, span := range c.pauses {
+		start, end := span.start, span.end
+		if start < from {
+			start = from
+		}
+		if end > to || end < 0 {
+			end = to
+		}
+		if end > start {
+			n += end - start
+		}
+	}
+	return n
+}
+
+// update follows the game rules entity, the horn tick is derived from how long
+// ago the game started, not counting pauses.
+func (c *GameClock) update(tick int, rules *PacketEntity) {
+	if state, ok := rules.GetInt("m_nGameState"); ok {
+		c.State = dota.DOTA_GameState(state)
+	}
+	start, _ := rules.GetFloat("m_flGameStartTime")
+	now, ok := rules.GetFloat("m_fGameTime")
+	if !ok || start <= 0 || start == c.startTime {
+		return
+	}
+	c.startTime = start
+
+	// walk back from tick until enough unpaused ticks are behind.
+	ticks := int(math.Round((now - start) / c.TickInterval))
+	horn := tick
+	for i := len(c.pauses) - 1; i >= 0 && ticks > 0; i-- {
+		span := c.pauses[i]
+		if span.start >= horn {
+			continue
+		}
+		end := span.end
+		if end < 0 || end > horn {
+			end = horn
+		}
+		if horn-end >= ticks {
+			break
+		}
+		ticks -= horn - end
+		horn = span.start
+	}
+	c.HornTick = horn - ticks
+}
+
+// setPaused records a pause or its end, it returns false if nothing changed.
+func (c *GameClock) setPaused(tick int, paused bool) bool {
+	if paused == c.paused {
+		return false
+	}
+	c.paused = paused
+	if paused {
+		// pauses after tick were recorded before seeking back.
+		n := len(c.pauses)
+		for n > 0 && c.pauses[n-1].start >= tick {
+			n--
+		}
+		c.pauses = append(c.pauses[:n], pauseSpan{start: tick, end: -1})
+	} else if n := len(c.pauses); n > 0 && c.pauses[n-1].end < 0 {
+		c.pauses[n-1].end = tick
+	}
+	return true
+}
+
+// rewind forgets the pauses from tick on, before the replay is parsed again
+// from there. The horn is found again with the next game rules, pauses that
+// were skipped by seeking forward would shift it otherwise.
+func (c *GameClock) rewind(tick int) {
+	c.startTime = 0
+	n := len(c.pauses)
+	for n > 0 && c.pauses[n-1].start >= tick {
+		n--
+	}
+	c.pauses = c.pauses[:n]
+	c.paused = n > 0 && (c.pauses[n-1].end < 0 || c.pauses[n-1].end > tick)
+	if c.paused {
+		c.pauses[n-1].end = -1
+	}
+}
+
+// updateClock updates the clock from the game rules entity.
+func (p *Parser) updateClock(tick int) error {
+	p.Clock().update(tick, p.gameRules)
+	if paused, ok := p.gameRules.GetInt("m_bGamePaused"); ok {
+		return p.setPaused(tick, paused != 0)
+	}
+	return nil
+}
+
+// setPaused is called for CSVCMsg_SetPause and changes of m_bGamePaused,
+// whichever comes first.
+func (p *Parser) setPaused(tick int, paused bool) error {
+	if !p.Clock().setPaused(tick, paused) || p.seeking {
+		return nil
+	}
+	if paused && p.OnPause != nil {
+		return p.OnPause(tick)
+	} else if !paused && p.OnUnpause != nil {
+		return p.OnUnpause(tick)
+	}
+	return nil
+}
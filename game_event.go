@@ -0,0 +1,64 @@
+package yasha
+
+import "github.com/dotabuff/yasha/dota"
+
+// GameEvent is a CSVCMsg_GameEvent decoded with the descriptor from the
+// CSVCMsg_GameEventList of the replay, so its Values are keyed by name.
+//
+// Values hold a string, float32, int32 (for long, short and byte keys), bool
+// or uint64, depending on the type of the key.
+type GameEvent struct {
+	Name   string
+	Tick   int
+	Values map[string]interface{}
+}
+
+// GameEventHandler is called for game events, see Parser.OnGameEvent and
+// Parser.OnGameEventNamed.
+type GameEventHandler func(tick int, event *GameEvent) error
+
+// OnGameEventNamed registers handler for all game events called name, like
+// "dota_chase_hero" or "hltv_status".
+func (p *Parser) OnGameEventNamed(name string, handler GameEventHandler) {
+	if p.gameEventHandlers == nil {
+		p.gameEventHandlers = map[string][]GameEventHandler{}
+	}
+	p.gameEventHandlers[name] = append(p.gameEventHandlers[name], handler)
+}
+
+func (p *Parser) newGameEvent(tick int, desc *dota.CSVCMsg_GameEventListDescriptorT, obj *dota.CSVCMsg_GameEvent) *GameEvent {
+	descKeys := desc.GetKeys()
+	keys := obj.GetKeys()
+	if len(keys) > len(descKeys) {
+		logTo(p.Logger, SeverityWarning, tick, desc.GetName(), "got %d keys, but the descriptor only has %d", len(keys), len(descKeys))
+		keys = keys[:len(descKeys)]
+	}
+
+	event := &GameEvent{
+		Name:   desc.GetName(),
+		Tick:   tick,
+		Values: make(map[string]interface{}, len(keys)),
+	}
+	for n, key := range keys {
+		name := descKeys[n].GetName()
+		switch key.GetType() {
+		case 1:
+			event.Values[name] = key.GetValString()
+		case 2:
+			event.Values[name] = key.GetValFloat()
+		case 3:
+			event.Values[name] = key.GetValLong()
+		case 4:
+			event.Values[name] = key.GetValShort()
+		case 5:
+			event.Values[name] = key.GetValByte()
+		case 6:
+			event.Values[name] = key.GetValBool()
+		case 7:
+			event.Values[name] = key.GetValUint64()
+		default:
+			logTo(p.Logger, SeverityWarning, tick, desc.GetName(), "unknown type %d of key %s", key.GetType(), name)
+		}
+	}
+	return event
+}
@@ -0,0 +1,301 @@
+package yasha
+
+import (
+	"fmt"
+	"sort"
+)
+
+type SlotKind int
+
+const (
+	SlotInventory SlotKind = iota
+	SlotBackpack
+	SlotStash
+)
+
+func (k SlotKind) String() string {
+	switch k {
+	case SlotInventory:
+		return "inventory"
+	case SlotBackpack:
+		return "backpack"
+	case SlotStash:
+		return "stash"
+	}
+	return fmt.Sprintf("SlotKind(%d)", int(k))
+}
+
+// InventorySlot is the content of one slot of m_hItems, Handle is 0 if it's
+// empty.
+type InventorySlot struct {
+	Kind    SlotKind
+	Handle  int
+	Item    string // like "item_blink"
+	Charges int
+}
+
+type ItemEventType int
+
+const (
+	ItemGained ItemEventType = iota
+	ItemSold
+	ItemDropped
+	ItemConsumed
+	ItemCombined
+	ItemCharges
+)
+
+func (t ItemEventType) String() string {
+	switch t {
+	case ItemGained:
+		return "gained"
+	case ItemSold:
+		return "sold"
+	case ItemDropped:
+		return "dropped"
+	case ItemConsumed:
+		return "consumed"
+	case ItemCombined:
+		return "combined"
+	case ItemCharges:
+		return "charges"
+	}
+	return fmt.Sprintf("ItemEventType(%d)", int(t))
+}
+
+// ItemEvent is a change of the inventory of a hero, see Parser.OnItem.
+//
+// The type is derived from what happened to the item entity: items that left
+// the inventory but still exist were dropped (or given to another hero, who
+// gains them). Deleted items were consumed if the hero used them in the
+// combat log of the tick. Otherwise they went into an item gained in the same
+// tick that wasn't bought as is, which is ItemCombined with them as
+// Components, or they were sold.
+type ItemEvent struct {
+	Type       ItemEventType
+	Tick       int
+	Hero       int // handle of the hero
+	Slot       int
+	Item       InventorySlot
+	Components []string // for ItemCombined
+}
+
+// Inventory follows the items of all heroes picked by players, see
+// Parser.TrackInventory.
+type Inventory struct {
+	Events []*ItemEvent
+
+	heroes  map[int]*heroInventory
+	current []InventorySlot
+	logs    []CombatLogEntry // purchases and item uses of the tick
+}
+
+type heroInventory struct {
+	slots   []InventorySlot
+	changes []slotChange
+}
+
+type slotChange struct {
+	tick int
+	slot int
+	item InventorySlot
+}
+
+// TrackInventory makes Parse follow the m_hItems of the heroes of all
+// players, calling OnItem for every change. The returned Inventory keeps all
+// events and what was in every slot at every tick.
+func (p *Parser) TrackInventory() *Inventory {
+	p.inventory = &Inventory{heroes: map[int]*heroInventory{}}
+	return p.inventory
+}
+
+// Slots returns what the hero had in its inventory, backpack and stash at the
+// end of tick.
+func (inv *Inventory) Slots(hero, tick int) []InventorySlot {
+	hi := inv.heroes[hero]
+	if hi == nil {
+		return nil
+	}
+	slots := make([]InventorySlot, len(hi.slots))
+	for i := range slots {
+		slots[i].Kind = slotKind(i, len(slots))
+	}
+	for _, change := range hi.changes {
+		if change.tick > tick {
+			break
+		}
+		slots[change.slot] = change.item
+	}
+	return slots
+}
+
+func (inv *Inventory) update(p *Parser, tick int) error {
+	defer func() {
+		inv.logs = inv.logs[:0]
+	}()
+
+	for _, player := range p.Match().Players {
+		if hero := p.hero(player.Slot); hero != nil {
+			if err := inv.updateHero(p, tick, hero); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (inv *Inventory) updateHero(p *Parser, tick int, hero *PacketEntity) error {
+	keys := p.arrayKeys(hero.ClassId, "m_hItems")
+	hi := inv.heroes[hero.Handle()]
+	if hi == nil {
+		hi = &heroInventory{slots: make([]InventorySlot, len(keys))}
+		for i := range hi.slots {
+			hi.slots[i].Kind = slotKind(i, len(keys))
+		}
+		inv.heroes[hero.Handle()] = hi
+	}
+
+	current := inv.current[:0]
+	changed := false
+	for i, key := range keys {
+		slot := InventorySlot{Kind: slotKind(i, len(keys))}
+		handle, _ := toInt(hero.Values[key])
+		if item := p.ByHandle[handle]; item != nil {
+			slot.Handle = handle
+			slot.Charges, _ = item.GetInt("m_iCurrentCharges")
+			if old := hi.slots[i]; old.Handle == handle {
+				slot.Item = old.Item
+			} else {
+				slot.Item = p.entityName(item)
+			}
+		}
+		current = append(current, slot)
+		changed = changed || slot != hi.slots[i]
+	}
+	inv.current = current
+	if !changed {
+		return nil
+	}
+
+	var events, lost []*ItemEvent
+	for i, slot := range hi.slots {
+		if slot.Handle != 0 && indexOfItem(current, slot.Handle) < 0 {
+			lost = append(lost, &ItemEvent{Type: ItemDropped, Tick: tick, Hero: hero.Handle(), Slot: i, Item: slot})
+		}
+	}
+	name, _ := hero.GetString("m_iszUnitName")
+	var deleted []*ItemEvent
+	for _, event := range lost {
+		if p.ByHandle[event.Item.Handle] != nil {
+			events = append(events, event)
+		} else if inv.used(name, event.Item.Item) {
+			event.Type = ItemConsumed
+			events = append(events, event)
+		} else {
+			deleted = append(deleted, event)
+		}
+	}
+
+	for i, slot := range current {
+		if slot != hi.slots[i] {
+			hi.changes = append(hi.changes, slotChange{tick: tick, slot: i, item: slot})
+		}
+		if slot.Handle == 0 {
+			continue
+		}
+		before := indexOfItem(hi.slots, slot.Handle)
+		if before < 0 {
+			event := &ItemEvent{Type: ItemGained, Tick: tick, Hero: hero.Handle(), Slot: i, Item: slot}
+			if len(deleted) > 0 && !inv.bought(name, slot.Item) {
+				event.Type = ItemCombined
+				for _, component := range deleted {
+					event.Components = append(event.Components, component.Item.Item)
+				}
+				deleted = nil
+			}
+			events = append(events, event)
+		} else if hi.slots[before].Charges != slot.Charges {
+			events = append(events, &ItemEvent{Type: ItemCharges, Tick: tick, Hero: hero.Handle(), Slot: i, Item: slot})
+		}
+	}
+	for _, event := range deleted {
+		event.Type = ItemSold
+		events = append(events, event)
+	}
+	copy(hi.slots, current)
+
+	sort.SliceStable(events, func(i, j int) bool { return events[i].Slot < events[j].Slot })
+	for _, event := range events {
+		inv.Events = append(inv.Events, event)
+		if p.OnItem != nil {
+			if err := p.OnItem(event); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// add keeps the purchases and item uses from the combat log.
+func (inv *Inventory) add(log CombatLogEntry) {
+	switch log.(type) {
+	case *CombatLogPurchase, *CombatLogItem:
+		inv.logs = append(inv.logs, log)
+	}
+}
+
+// bought is true if the hero bought item in this tick.
+func (inv *Inventory) bought(hero, item string) bool {
+	for _, log := range inv.logs {
+		if purchase, ok := log.(*CombatLogPurchase); ok && purchase.Buyer == hero && purchase.Item == item {
+			return true
+		}
+	}
+	return false
+}
+
+// used is true if the hero used item in this tick.
+func (inv *Inventory) used(hero, item string) bool {
+	for _, log := range inv.logs {
+		if use, ok := log.(*CombatLogItem); ok && use.User == hero && use.Item == item {
+			return true
+		}
+	}
+	return false
+}
+
+// slotKind tells where a slot is, replays with a backpack have 15 or more.
+func slotKind(slot, slots int) SlotKind {
+	switch {
+	case slot < 6:
+		return SlotInventory
+	case slots >= 15 && slot < 9:
+		return SlotBackpack
+	}
+	return SlotStash
+}
+
+func indexOfItem(slots []InventorySlot, handle int) int {
+	for i, slot := range slots {
+		if slot.Handle == handle {
+			return i
+		}
+	}
+	return -1
+}
+
+// entityName returns the name of an item or ability entity, m_iName is either the name or
+// its index in the EntityNames string table. Falls back to the class.
+func (p *Parser) entityName(item *PacketEntity) string {
+	if name, ok := item.GetString("m_iName"); ok && name != "" {
+		return name
+	}
+	if index, ok := item.GetInt("m_iName"); ok {
+		if table := p.Stsh.GetTableNow("EntityNames"); table != nil {
+			if entry := table.Items[index]; entry != nil && entry.Str != "" {
+				return entry.Str
+			}
+		}
+	}
+	return item.Name
+}
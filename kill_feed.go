@@ -0,0 +1,214 @@
+package yasha
+
+import "time"
+
+// goldReasonHeroKill is the CombatLogGold reason for a hero kill bounty,
+// EDOTA_ModifyGold_HeroKill.
+const goldReasonHeroKill = 12
+
+// KillRules decide who gets credit for a kill.
+type KillRules struct {
+	// AssistWindow is how long before the death damage, debuffs and heals
+	// still count for an assist.
+	AssistWindow time.Duration
+	// Debuffs makes heroes that debuffed the victim assisters.
+	Debuffs bool
+	// Heals makes heroes that healed the killer or another assister
+	// assisters.
+	Heals bool
+}
+
+// DefaultKillRules are close to how the game counts assists.
+var DefaultKillRules = KillRules{
+	AssistWindow: 20 * time.Second,
+	Debuffs:      true,
+	Heals:        true,
+}
+
+// Kill is a death of a hero, see Parser.TrackKills. Heroes are unit names like
+// "npc_dota_hero_axe", kills by illusions belong to their hero. Killer is the
+// unit that got the last hit if it wasn't a hero, like a tower.
+type Kill struct {
+	Tick       int
+	GameTime   time.Duration // only valid if the game clock was Started
+	Victim     string
+	Killer     string
+	Assisters  []string // in the order they got involved
+	Ability    string   // the cause of death, like "dota_unknown" for attacks
+	GoldBounty int      // given to the killer and assisters
+	Position   Vector3  // of the victim, if its hero entity is known
+}
+
+// KillFeed turns hero deaths in the combat log into kills with assists.
+type KillFeed struct {
+	Rules KillRules
+	Kills []*Kill
+
+	// recent damage and debuffs per victim, and heals per healed hero.
+	hits   map[string][]contribution
+	heals  map[string][]contribution
+	deaths []*CombatLogDeath
+	gold   []*CombatLogGold
+}
+
+type contribution struct {
+	hero string
+	time float32
+}
+
+// TrackKills makes Parse follow the combat log, calling OnKill for every hero
+// that dies. The returned KillFeed keeps all kills.
+func (p *Parser) TrackKills(rules KillRules) *KillFeed {
+	p.kills = &KillFeed{
+		Rules: rules,
+		hits:  map[string][]contribution{},
+		heals: map[string][]contribution{},
+	}
+	return p.kills
+}
+
+func (f *KillFeed) add(log CombatLogEntry) {
+	switch log := log.(type) {
+	case *CombatLogDamage:
+		if log.AttackerIsHero && log.TargetIsHero && !log.TargetIsIllusion {
+			f.hits[log.Target] = f.contribute(f.hits[log.Target], log.Attacker, log.Time)
+		}
+	case *CombatLogModifierAdd:
+		if f.Rules.Debuffs && log.IsDebuff && log.AttackerIsHero && log.TargetIsHero && !log.TargetIsIllusion {
+			f.hits[log.Target] = f.contribute(f.hits[log.Target], log.Attacker, log.Time)
+		}
+	case *CombatLogHeal:
+		if f.Rules.Heals && log.AttackerIsHero && log.TargetIsHero && log.Attacker != log.Target {
+			f.heals[log.Target] = f.contribute(f.heals[log.Target], log.Attacker, log.Time)
+		}
+	case *CombatLogDeath:
+		if log.TargetIsHero && !log.TargetIsIllusion {
+			f.deaths = append(f.deaths, log)
+		}
+	case *CombatLogGold:
+		if log.Reason == goldReasonHeroKill {
+			f.gold = append(f.gold, log)
+		}
+	}
+}
+
+// contribute adds hero to recent, dropping what's out of the window.
+func (f *KillFeed) contribute(recent []contribution, hero string, now float32) []contribution {
+	n := 0
+	for _, c := range recent {
+		if c.hero != hero && !f.expired(c, now) {
+			recent[n] = c
+			n++
+		}
+	}
+	return append(recent[:n], contribution{hero: hero, time: now})
+}
+
+func (f *KillFeed) expired(c contribution, now float32) bool {
+	return float64(now-c.time) > f.Rules.AssistWindow.Seconds()
+}
+
+// update turns the deaths of the tick into kills, once the bounties of the
+// tick are known too.
+func (f *KillFeed) update(p *Parser, tick int) error {
+	defer func() {
+		f.deaths = f.deaths[:0]
+		f.gold = f.gold[:0]
+	}()
+
+	for _, death := range f.deaths {
+		kill := &Kill{
+			Tick:    tick,
+			Victim:  death.Target,
+			Killer:  death.Attacker,
+			Ability: death.Cause,
+		}
+		kill.GameTime, _ = p.Clock().TickToGameTime(tick)
+		if hero := p.heroByName(death.Target); hero != nil {
+			kill.Position, _ = hero.Position()
+		}
+
+		// the heroes that hit the victim, then those that healed any of them.
+		heroes := []string{death.Attacker}
+		for _, c := range f.hits[death.Target] {
+			if !f.expired(c, death.Time) && p.enemies(c.hero, death.Target) {
+				heroes = appendUnique(heroes, c.hero)
+			}
+		}
+		for i := 0; i < len(heroes); i++ {
+			for _, c := range f.heals[heroes[i]] {
+				if !f.expired(c, death.Time) && p.enemies(c.hero, death.Target) {
+					heroes = appendUnique(heroes, c.hero)
+				}
+			}
+		}
+		kill.Assisters = heroes[1:]
+		delete(f.hits, death.Target)
+
+		// every bounty goes to one kill, when heroes die together.
+		n := 0
+		for _, gold := range f.gold {
+			if gold.Target == kill.Killer || containsString(kill.Assisters, gold.Target) {
+				kill.GoldBounty += gold.Value
+			} else {
+				f.gold[n] = gold
+				n++
+			}
+		}
+		f.gold = f.gold[:n]
+
+		f.Kills = append(f.Kills, kill)
+		if p.OnKill != nil {
+			if err := p.OnKill(kill); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// heroByName returns the hero entity of a player by its unit name.
+func (p *Parser) heroByName(name string) *PacketEntity {
+	for _, player := range p.Match().Players {
+		if hero := p.hero(player.Slot); hero != nil {
+			if unit, _ := hero.GetString("m_iszUnitName"); unit == name {
+				return hero
+			}
+		}
+	}
+	return nil
+}
+
+// enemies is false if both heroes are known to be on the same team.
+func (p *Parser) enemies(a, b string) bool {
+	if a == b {
+		return false
+	}
+	teamA, teamB := p.heroTeam(a), p.heroTeam(b)
+	return teamA == 0 || teamB == 0 || teamA != teamB
+}
+
+func (p *Parser) heroTeam(name string) int {
+	for _, player := range p.Match().Players {
+		if player.Hero == name {
+			return player.Team
+		}
+	}
+	return 0
+}
+
+func appendUnique(list []string, s string) []string {
+	if containsString(list, s) {
+		return list
+	}
+	return append(list, s)
+}
+
+func containsString(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,58 @@
+package yasha
+
+import "fmt"
+
+type Severity int
+
+const (
+	SeverityDebug Severity = iota
+	SeverityInfo
+	SeverityWarning
+)
+
+func (s Severity) String() string {
+	switch s {
+	case SeverityDebug:
+		return "debug"
+	case SeverityInfo:
+		return "info"
+	case SeverityWarning:
+		return "warning"
+	}
+	return fmt.Sprintf("Severity(%d)", int(s))
+}
+
+// Diagnostic describes something unexpected that didn't stop parsing, like
+// messages or game events yasha doesn't know about.
+type Diagnostic struct {
+	Severity    Severity
+	Tick        int
+	MessageType string // e.g. "CSVCMsg_GameEvent", "dota_combatlog" or "NETSVC 123"
+	Message     string
+}
+
+func (d Diagnostic) String() string {
+	return fmt.Sprintf("%s: tick %d: %s: %s", d.Severity, d.Tick, d.MessageType, d.Message)
+}
+
+// Logger receives the diagnostics of a Parser, which are dropped if it's nil.
+type Logger interface {
+	Log(Diagnostic)
+}
+
+// LoggerFunc turns a function into a Logger.
+type LoggerFunc func(Diagnostic)
+
+func (f LoggerFunc) Log(d Diagnostic) { f(d) }
+
+func logTo(l Logger, severity Severity, tick int, messageType, format string, args ...interface{}) {
+	if l == nil {
+		return
+	}
+	l.Log(Diagnostic{
+		Severity:    severity,
+		Tick:        tick,
+		MessageType: messageType,
+		Message:     fmt.Sprintf(format, args...),
+	})
+}
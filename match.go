@@ -0,0 +1,223 @@
+package yasha
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/dotabuff/yasha/dota"
+)
+
+// Teams as used by entities (m_iTeamNum) and the file info.
+const (
+	TeamRadiant = 2
+	TeamDire    = 3
+)
+
+// MatchSummary tells who played what in a replay, see Parser.Match.
+type MatchSummary struct {
+	MatchID        uint32
+	LeagueID       uint32
+	GameMode       dota.DOTA_GameMode
+	Duration       time.Duration // of the replay
+	Winner         int           // TeamRadiant, TeamDire, or 0 if unknown
+	RadiantTeamID  uint32
+	DireTeamID     uint32
+	RadiantTeamTag string
+	DireTeamTag    string
+	PicksBans      []PickBan
+	Players        []*PlayerSummary // ordered by Slot
+}
+
+// PickBan is one step of the draft, in the order they happened.
+type PickBan struct {
+	Pick   bool // false for bans
+	Team   int
+	HeroID int
+}
+
+type PlayerSummary struct {
+	Slot      int // player ID, usually 0-4 for radiant and 5-9 for dire
+	Team      int
+	SteamID   uint64
+	AccountID uint32 // as used by the Dota 2 web API
+	Name      string
+	HeroID    int
+	Hero      string // like "npc_dota_hero_axe"
+}
+
+// Match returns what's known about the match and its players so far. Players
+// show up once the player resource entity has them, the match ID, draft and
+// winner once the file info at the end of the replay is read, so after Parse
+// everything is there.
+func (p *Parser) Match() *MatchSummary {
+	if p.match == nil {
+		p.match = &MatchSummary{}
+	}
+	return p.match
+}
+
+// updatePlayers reads the players from the DT_DOTA_PlayerResource entity.
+func (p *Parser) updatePlayers() {
+	match := p.Match()
+	for slot := range p.resourceKeys("m_iPlayerTeams") {
+		team, _ := toInt(p.resourceValue("m_iPlayerTeams", slot))
+		if team != TeamRadiant && team != TeamDire {
+			continue
+		}
+		player := match.player(slot)
+		player.Team = team
+		if steamID, ok := p.resourceValue("m_iPlayerSteamIDs", slot).(uint64); ok && steamID != 0 {
+			player.setSteamID(steamID)
+		}
+		if name, ok := p.resourceValue("m_iszPlayerNames", slot).(string); ok && name != "" {
+			player.Name = name
+		}
+		if player.Name == "" && player.SteamID != 0 {
+			player.Name = p.userName(player.SteamID)
+		}
+		if heroID, ok := toInt(p.resourceValue("m_nSelectedHeroID", slot)); ok && heroID != 0 {
+			player.HeroID = heroID
+		}
+		if hero := p.hero(slot); hero != nil && player.Hero == "" {
+			player.Hero, _ = hero.GetString("m_iszUnitName")
+		}
+	}
+}
+
+// hero returns the hero entity of the player in slot, if it's known.
+func (p *Parser) hero(slot int) *PacketEntity {
+	handle, ok := toInt(p.resourceValue("m_hSelectedHero", slot))
+	if !ok {
+		return nil
+	}
+	return p.ByHandle[handle]
+}
+
+// resourceValue returns the element for slot of the player resource array
+// prop name, like "m_iKills".
+func (p *Parser) resourceValue(name string, slot int) interface{} {
+	keys := p.resourceKeys(name)
+	if slot >= len(keys) {
+		return nil
+	}
+	return p.playerResource.Values[keys[slot]]
+}
+
+// resourceKeys returns the keys of the elements of the player resource array
+// prop name, one per slot. Depending on the replay they are named "0000" or
+// "000".
+func (p *Parser) resourceKeys(name string) []string {
+	if p.playerResource == nil {
+		return nil
+	}
+	keys, ok := p.playerKeys[name]
+	if ok {
+		return keys
+	}
+	for _, format := range []string{"DT_DOTA_PlayerResource.%s.%04d", "DT_DOTA_PlayerResource.%s.%03d"} {
+		for slot := 0; ; slot++ {
+			key := fmt.Sprintf(format, name, slot)
+			if _, ok := p.playerResource.Values[key]; !ok {
+				break
+			}
+			keys = append(keys, key)
+		}
+		if len(keys) > 0 {
+			break
+		}
+	}
+	if p.playerKeys == nil {
+		p.playerKeys = map[string][]string{}
+	}
+	p.playerKeys[name] = keys
+	return keys
+}
+
+// userName looks the player up in the userinfo string table.
+func (p *Parser) userName(steamID uint64) string {
+	table := p.Stsh.GetTableNow("userinfo")
+	if table == nil {
+		return ""
+	}
+	for _, item := range table.Items {
+		if item.Userinfo != nil && item.Userinfo.SteamID == steamID {
+			return item.Userinfo.Name
+		}
+	}
+	return ""
+}
+
+// updateMatch fills in the match from the file info, which also has the name,
+// team and hero of every player.
+func (p *Parser) updateMatch(info *dota.CDemoFileInfo) {
+	match := p.Match()
+	game := info.GetGameInfo().GetDota()
+	match.MatchID = game.GetMatchId()
+	match.LeagueID = game.GetLeagueid()
+	match.GameMode = dota.DOTA_GameMode(game.GetGameMode())
+	match.Duration = time.Duration(float64(info.GetPlaybackTime()) * float64(time.Second))
+	match.Winner = int(game.GetGameWinner())
+	match.RadiantTeamID = game.GetRadiantTeamId()
+	match.DireTeamID = game.GetDireTeamId()
+	match.RadiantTeamTag = game.GetRadiantTeamTag()
+	match.DireTeamTag = game.GetDireTeamTag()
+
+	match.PicksBans = match.PicksBans[:0]
+	for _, event := range game.GetPicksBans() {
+		match.PicksBans = append(match.PicksBans, PickBan{
+			Pick:   event.GetIsPick(),
+			Team:   int(event.GetTeam()),
+			HeroID: int(event.GetHeroId()),
+		})
+	}
+
+	for slot, info := range game.GetPlayerInfo() {
+		player := match.bySteamID(info.GetSteamid())
+		if player == nil {
+			player = match.player(slot)
+			player.setSteamID(info.GetSteamid())
+		}
+		if player.Team == 0 {
+			player.Team = int(info.GetGameTeam())
+		}
+		if info.GetPlayerName() != "" {
+			player.Name = info.GetPlayerName()
+		}
+		if info.GetHeroName() != "" {
+			player.Hero = info.GetHeroName()
+		}
+	}
+}
+
+// player returns the player in slot, adding it if it's new.
+func (m *MatchSummary) player(slot int) *PlayerSummary {
+	i := sort.Search(len(m.Players), func(i int) bool { return m.Players[i].Slot >= slot })
+	if i < len(m.Players) && m.Players[i].Slot == slot {
+		return m.Players[i]
+	}
+	player := &PlayerSummary{Slot: slot}
+	m.Players = append(m.Players, nil)
+	copy(m.Players[i+1:], m.Players[i:])
+	m.Players[i] = player
+	return player
+}
+
+func (m *MatchSummary) bySteamID(steamID uint64) *PlayerSummary {
+	if steamID == 0 {
+		return nil
+	}
+	for _, player := range m.Players {
+		if player.SteamID == steamID {
+			return player
+		}
+	}
+	return nil
+}
+
+func (ps *PlayerSummary) setSteamID(steamID uint64) {
+	ps.SteamID = steamID
+	if steamID > steamID64Identifier {
+		ps.AccountID = uint32(steamID - steamID64Identifier)
+	}
+}
@@ -1,158 +1,415 @@
 package yasha
 
 import (
+	"bufio"
 	"bytes"
+	"compress/bzip2"
+	"fmt"
+	"io"
+	"os"
+	"reflect"
 	"strings"
+	"sync"
 
-	"github.com/davecgh/go-spew/spew"
 	"github.com/dotabuff/yasha/dota"
+	"github.com/golang/protobuf/proto"
+	"github.com/siddontang/go/snappy"
 )
 
 type OuterParser struct {
-	reader   *BytesReader
+	reader   *bufio.Reader
+	closer   io.Closer
+	seeker   io.ReadSeeker
+	base     int64
+	position int
+	offset   int
 	Sequence int64
 	Items    map[int64]*OuterParserItem
+	Logger   Logger
+
+	// index of DEM_FullPacket frames, see indexFullPackets.
+	fullPackets []fullPacket
+	scanned     int
+	scannedTick int
+	scanDone    bool
+
+	// reused for every frame, see outer_parser_pool.go. pool guards the free
+	// lists, which are shared with AnalyzeConcurrently.
+	pool         sync.Mutex
+	frame        []byte
+	uncompressed []byte
+	packet       dota.CDemoPacket
+	userMessage  dota.CSVCMsg_UserMessage
+	items        []*OuterParserBaseItem
+	borrow       bool
+	free         map[int][]proto.Message
+	keys         map[reflect.Type]int
+}
+
+// frameHeader precedes every EDemoCommands message in the replay.
+type frameHeader struct {
+	command    dota.EDemoCommands
+	compressed bool
+	tick       int
+	length     int
+	offset     int
 }
 
-func OuterParserFromFile(path string) *OuterParser {
-	if strings.HasSuffix(path, ".dem.bz2") {
-		return NewOuterParser(ReadBz2File(path))
-	} else if strings.HasSuffix(path, ".dem") {
-		return NewOuterParser(ReadFile(path))
-	} else {
-		panic("expected path to .dem or .dem.bz2 instead of " + path)
+// OuterParserFromFile opens a .dem or .dem.bz2 replay, the file is read (and
+// decompressed) as the parser goes along.
+func OuterParserFromFile(path string) (*OuterParser, error) {
+	if !strings.HasSuffix(path, ".dem.bz2") && !strings.HasSuffix(path, ".dem") {
+		return nil, fmt.Errorf("expected path to .dem or .dem.bz2 instead of %s", path)
+	}
+	fd, err := os.Open(path)
+	if err != nil {
+		return nil, err
 	}
+	var r io.Reader = fd
+	if strings.HasSuffix(path, ".bz2") {
+		r = bzip2.NewReader(fd)
+	}
+	p, err := NewOuterParserFromReader(r)
+	if err != nil {
+		fd.Close()
+		return nil, err
+	}
+	p.closer = fd
+	return p, nil
 }
 
-func NewOuterParser(data []byte) *OuterParser {
-	if len(data) < headerLength {
-		panic("File too small.")
+func NewOuterParser(data []byte) (*OuterParser, error) {
+	return NewOuterParserFromReader(bytes.NewReader(data))
+}
+
+// NewOuterParserFromReader reads the replay from r one frame at a time, so only
+// the current frame is held in memory.
+func NewOuterParserFromReader(r io.Reader) (*OuterParser, error) {
+	p := &OuterParser{reader: bufio.NewReaderSize(r, readerBufferSize), Sequence: 1, scanned: headerLength}
+	if seeker, ok := r.(io.ReadSeeker); ok {
+		if base, err := seeker.Seek(0, io.SeekCurrent); err == nil {
+			p.seeker, p.base = seeker, base
+		}
+	}
+
+	header := make([]byte, headerLength)
+	n, err := io.ReadFull(p.reader, header)
+	p.position += n
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return nil, &ParseError{Err: ErrTruncated, Offset: n}
+	} else if err != nil {
+		return nil, err
 	}
 
-	magic := ReadStringZ(data, 0)
+	magic := ReadStringZ(header, 0)
 	if magic != headerMagic {
-		panic("demofilestamp doesn't match, was: " + spew.Sdump(magic))
+		return nil, &ParseError{Err: ErrBadMagic, Cause: fmt.Errorf("was %q", magic)}
 	}
 
-	totalLength := len(data) - headerLength
-	if totalLength < 1 {
-		panic("couldn't open file")
+	return p, nil
+}
+
+// Close closes the underlying file, if the parser opened one.
+func (p *OuterParser) Close() error {
+	if p.closer == nil {
+		return nil
 	}
+	err := p.closer.Close()
+	p.closer = nil
+	return err
+}
 
-	buffer := data[headerLength:(headerLength + totalLength)]
-	return &OuterParser{reader: NewBytesReader(buffer)}
+func (p *OuterParser) readByte() (byte, error) {
+	b, err := p.reader.ReadByte()
+	if err == nil {
+		p.position++
+	}
+	return b, err
 }
 
-func (p *OuterParser) readEDemoCommands() (dota.EDemoCommands, bool) {
-	command := dota.EDemoCommands(p.reader.ReadVarInt32())
-	compressed := (command & dota.EDemoCommands_DEM_IsCompressed) == dota.EDemoCommands_DEM_IsCompressed
-	command = command & ^dota.EDemoCommands_DEM_IsCompressed
-	return command, compressed
+// readVarInt32 works like BytesReader.ReadVarInt32, but returns io.EOF if the
+// stream ends before the first byte and io.ErrUnexpectedEOF if it ends after.
+func (p *OuterParser) readVarInt32() (result int32, err error) {
+	for count := uint(0); count < 5; count++ {
+		b, err := p.readByte()
+		if err == io.EOF && count > 0 {
+			return result, io.ErrUnexpectedEOF
+		} else if err != nil {
+			return result, err
+		}
+		result |= int32(b&0x7F) << (7 * count)
+		if b&0x80 == 0 {
+			break
+		}
+	}
+	return result, nil
 }
 
-func (p *OuterParser) Analyze(callback func(*OuterParserBaseItem)) {
-	p.Sequence = 1
-	command, compressed := p.readEDemoCommands()
-	if command == dota.EDemoCommands_DEM_Error {
-		panic(command)
+// readFrameHeader returns io.EOF once the replay ends cleanly.
+func (p *OuterParser) readFrameHeader() (h frameHeader, err error) {
+	h.offset = p.position
+	command, err := p.readVarInt32()
+	if err != nil {
+		return h, p.frameError(h, err)
 	}
-	for p.reader.CanRead() {
-		tick := int(p.reader.ReadVarInt32())
-		length := int(p.reader.ReadVarInt32())
-		obj, err := p.AsBaseEvent(command.String())
-		if err == nil {
-			item := &OuterParserItem{
-				Sequence: p.Sequence,
-				Object:   obj,
-				Tick:     tick,
-			}
-			p.Sequence++
-			if compressed {
-				item.Data = SnappyUncompress(p.reader.Read(length))
-			} else {
-				item.Data = p.reader.Read(length)
-			}
-			switch o := obj.(type) {
-			case *SignonPacket:
-				full := &dota.CDemoPacket{}
-				ProtoUnmarshal(item.Data, full)
-				p.AnalyzePacket(callback, dota.EDemoCommands_DEM_SignonPacket, tick, full.GetData())
-			case *dota.CDemoPacket:
-				ProtoUnmarshal(item.Data, o)
-				p.AnalyzePacket(callback, dota.EDemoCommands_DEM_Packet, tick, o.GetData())
-			case *dota.CDemoFullPacket:
-				ProtoUnmarshal(item.Data, o)
-				item.From = dota.EDemoCommands_DEM_FullPacket
-				item.Data = nil
-				item.Object = o.GetStringTable()
-				callback(parseOne(item))
-				p.AnalyzePacket(callback, dota.EDemoCommands_DEM_FullPacket, tick, o.GetPacket().GetData())
-			case *dota.CDemoSendTables:
-				ProtoUnmarshal(item.Data, o)
-				p.AnalyzePacket(callback, dota.EDemoCommands_DEM_SendTables, tick, o.GetData())
-			default:
-				callback(parseOne(item))
-			}
-		} else {
-			p.reader.Skip(length)
+	h.command = dota.EDemoCommands(command)
+	h.compressed = (h.command & dota.EDemoCommands_DEM_IsCompressed) == dota.EDemoCommands_DEM_IsCompressed
+	h.command = h.command & ^dota.EDemoCommands_DEM_IsCompressed
+	if h.command == dota.EDemoCommands_DEM_Error {
+		return h, &ParseError{Err: ErrBadCommand, Offset: h.offset, Cause: fmt.Errorf("%s", h.command)}
+	}
+
+	// a trailing command without tick is how replays usually end.
+	tick, err := p.readVarInt32()
+	if err != nil {
+		return h, p.frameError(h, err)
+	}
+	h.tick = int(tick)
+	length, err := p.readVarInt32()
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	if err != nil {
+		return h, p.frameError(h, err)
+	}
+	h.length = int(length)
+	if h.length < 0 {
+		return h, &ParseError{Err: ErrTruncated, Tick: h.tick, Offset: h.offset}
+	} else if h.length > maxFrameSize {
+		return h, &ParseError{Err: ErrDecode, Tick: h.tick, Offset: h.offset, Cause: fmt.Errorf("frame of %d bytes", h.length)}
+	}
+	p.indexFrame(h, p.position+h.length)
+	return h, nil
+}
+
+func (p *OuterParser) frameError(h frameHeader, err error) error {
+	if err == io.EOF {
+		return err
+	} else if err == io.ErrUnexpectedEOF {
+		return &ParseError{Err: ErrTruncated, Tick: h.tick, Offset: h.offset}
+	}
+	return &ParseError{Err: ErrTruncated, Tick: h.tick, Offset: h.offset, Cause: err}
+}
+
+// frameData returns the uncompressed data of the frame, which is only valid
+// until the next frame is read. raw is the data read already, if it's nil the
+// data is read from the replay.
+func (p *OuterParser) frameData(h frameHeader, raw []byte) ([]byte, error) {
+	if raw == nil {
+		p.frame = buffer(p.frame, h.length)
+		raw = p.frame
+		if err := p.readFrame(h, raw); err != nil {
+			return nil, err
 		}
-		command, compressed = p.readEDemoCommands()
-		if command == dota.EDemoCommands_DEM_Error {
-			panic(command)
+	}
+	return p.decompress(h, raw)
+}
+
+// readFrame reads the data of the frame h into data, which has to be as long
+// as the frame.
+func (p *OuterParser) readFrame(h frameHeader, data []byte) error {
+	n, err := io.ReadFull(p.reader, data)
+	p.position += n
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	if err != nil {
+		return p.frameError(h, err)
+	}
+	return nil
+}
+
+func (p *OuterParser) decompress(h frameHeader, data []byte) ([]byte, error) {
+	if !h.compressed {
+		return data, nil
+	}
+	size, err := snappy.DecodedLen(data)
+	if err != nil {
+		return nil, &ParseError{Err: ErrSnappy, Tick: h.tick, Offset: h.offset, Cause: err}
+	} else if size > maxFrameSize {
+		return nil, &ParseError{Err: ErrSnappy, Tick: h.tick, Offset: h.offset, Cause: fmt.Errorf("frame of %d bytes", size)}
+	}
+	p.uncompressed = buffer(p.uncompressed, size)
+	data, err = snappy.Decode(p.uncompressed, data)
+	if err != nil {
+		return nil, &ParseError{Err: ErrSnappy, Tick: h.tick, Offset: h.offset, Cause: err}
+	}
+	return data, nil
+}
+
+func (p *OuterParser) skipFrameData(h frameHeader) error {
+	n, err := p.reader.Discard(h.length)
+	p.position += n
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	if err != nil {
+		return p.frameError(h, err)
+	}
+	return nil
+}
+
+// Analyze reads all frames and passes every message they contain to callback.
+// It stops at the first error, either from the replay or from callback.
+func (p *OuterParser) Analyze(callback func(*OuterParserBaseItem) error) error {
+	for {
+		err := p.analyzeFrame(callback)
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
 		}
 	}
 }
 
-func (p *OuterParser) AnalyzePacket(callback func(*OuterParserBaseItem), fromEvent dota.EDemoCommands, tick int, data []byte) {
+// analyzeFrame reads the next frame and passes its messages to callback.
+func (p *OuterParser) analyzeFrame(callback func(*OuterParserBaseItem) error) error {
+	h, err := p.readFrameHeader()
+	if err != nil {
+		return err
+	}
+	return p.analyzeFrameData(callback, h, nil)
+}
+
+// analyzeFrameData passes the messages of the frame h to callback. raw is the
+// data of the frame if it was read already, otherwise it's read from the
+// replay.
+func (p *OuterParser) analyzeFrameData(callback func(*OuterParserBaseItem) error, h frameHeader, raw []byte) (err error) {
+	p.offset = h.offset
+
+	// the most common frames, which are unpacked into p.packet right away.
+	if h.command == dota.EDemoCommands_DEM_Packet || h.command == dota.EDemoCommands_DEM_SignonPacket {
+		item := &OuterParserItem{Tick: h.tick, Offset: h.offset}
+		p.Sequence++
+		if item.Data, err = p.frameData(h, raw); err != nil {
+			return err
+		}
+		if err := p.unmarshal(item, &p.packet); err != nil {
+			return err
+		}
+		return p.AnalyzePacket(callback, h.command, item.Tick, p.packet.GetData())
+	}
+
+	obj, err := p.AsBaseEvent(h.command.String())
+	if err != nil {
+		if raw != nil {
+			return nil
+		}
+		return p.skipFrameData(h)
+	}
+
+	item := &OuterParserItem{
+		Sequence: p.Sequence,
+		Object:   obj,
+		Tick:     h.tick,
+		Offset:   h.offset,
+	}
+	p.Sequence++
+	if item.Data, err = p.frameData(h, raw); err != nil {
+		return err
+	}
+	return p.analyzeItem(callback, item)
+}
+
+func (p *OuterParser) analyzeItem(callback func(*OuterParserBaseItem) error, item *OuterParserItem) error {
+	switch o := item.Object.(type) {
+	case *dota.CDemoFullPacket:
+		if err := p.unmarshal(item, o); err != nil {
+			return err
+		}
+		item.From = dota.EDemoCommands_DEM_FullPacket
+		item.Data = nil
+		item.Object = o.GetStringTable()
+		base, err := p.parseOne(item)
+		if err != nil {
+			return err
+		}
+		if err = callback(base); err != nil {
+			return err
+		}
+		return p.AnalyzePacket(callback, dota.EDemoCommands_DEM_FullPacket, item.Tick, o.GetPacket().GetData())
+	case *dota.CDemoSendTables:
+		if err := p.unmarshal(item, o); err != nil {
+			return err
+		}
+		return p.AnalyzePacket(callback, dota.EDemoCommands_DEM_SendTables, item.Tick, o.GetData())
+	default:
+		base, err := p.parseOne(item)
+		if err != nil {
+			return err
+		}
+		return callback(base)
+	}
+}
+
+func (p *OuterParser) unmarshal(item *OuterParserItem, obj proto.Message) error {
+	if err := ProtoUnmarshal(item.Data, obj); err != nil {
+		return &ParseError{Err: ErrProtoDecode, Tick: item.Tick, Offset: item.Offset, Cause: err}
+	}
+	return nil
+}
+
+func (p *OuterParser) AnalyzePacket(callback func(*OuterParserBaseItem) error, fromEvent dota.EDemoCommands, tick int, data []byte) error {
 	reader := NewBytesReader(data)
 	for reader.CanRead() {
 		iType := int(reader.ReadVarInt32())
 		length := int(reader.ReadVarInt32())
-		obj, err := p.AsBaseEventNETSVC(iType)
+		if length < 0 || length > reader.Remaining() {
+			return &ParseError{Err: ErrTruncated, Tick: tick, Offset: p.offset}
+		}
+		obj, err := p.newMessage(messageNETSVC, iType)
 		if err != nil {
-			spew.Println(err)
+			logTo(p.Logger, SeverityWarning, tick, fmt.Sprintf("NETSVC %d", iType), "unknown message type, skipping %d bytes", length)
 			reader.Skip(length)
-		} else {
-			item := &OuterParserItem{
-				Sequence: p.Sequence,
-				From:     fromEvent,
-				Object:   obj,
-				Tick:     tick,
-				Data:     reader.Read(length),
+			continue
+		}
+		item := &OuterParserItem{
+			Sequence: p.Sequence,
+			From:     fromEvent,
+			Object:   obj,
+			Tick:     tick,
+			Offset:   p.offset,
+			Data:     reader.Read(length),
+		}
+		p.Sequence++
+		switch obj.(type) {
+		case *dota.CSVCMsg_UserMessage:
+			message := &p.userMessage
+			if err := p.unmarshal(item, message); err != nil {
+				return err
 			}
-			p.Sequence++
-			switch obj.(type) {
-			case *dota.CSVCMsg_UserMessage:
-				message := &dota.CSVCMsg_UserMessage{}
-				ProtoUnmarshal(item.Data, message)
-				um, err := p.AsBaseEventBUMDUM(int(message.GetMsgType()))
-				if err == nil {
-					item.Object = um
-					item.Data = message.GetMsgData()
-					callback(parseOne(item))
-				}
-			default:
-				callback(parseOne(item))
+			um, err := p.newMessage(messageBUMDUM, int(message.GetMsgType()))
+			if err != nil {
+				logTo(p.Logger, SeverityWarning, tick, fmt.Sprintf("BUMDUM %d", message.GetMsgType()), "unknown user message type, skipping %d bytes", len(message.GetMsgData()))
+				continue
 			}
+			item.Object = um
+			item.Data = message.GetMsgData()
+		}
+		base, err := p.parseOne(item)
+		if err != nil {
+			return err
+		}
+		if err = callback(base); err != nil {
+			return err
 		}
 	}
+	return nil
 }
 
-func parseOne(item *OuterParserItem) *OuterParserBaseItem {
+func (p *OuterParser) parseOne(item *OuterParserItem) (*OuterParserBaseItem, error) {
 	err := ProtoUnmarshal(item.Data, item.Object)
 	if err != nil {
-		spew.Println("parseOne()")
-		spew.Dump(item)
-		panic(err)
-		return &OuterParserBaseItem{}
+		return nil, &ParseError{Err: ErrProtoDecode, Tick: item.Tick, Offset: item.Offset, Cause: err}
 	}
 	item.Data = nil
-	return &OuterParserBaseItem{
-		Sequence: item.Sequence,
-		Tick:     item.Tick,
-		From:     item.From,
-		Object:   item.Object,
-	}
+	base := p.newItem()
+	base.Sequence = item.Sequence
+	base.Tick = item.Tick
+	base.Offset = item.Offset
+	base.From = item.From
+	base.Object = item.Object
+	return base, nil
 }
 
 func ReadStringZ(datas []byte, offset int) string {
@@ -11,43 +11,35 @@ import (
 	"github.com/siddontang/go/snappy"
 )
 
-func SnappyUncompress(compressed []byte) []byte {
+func SnappyUncompress(compressed []byte) ([]byte, error) {
 	dst := make([]byte, 0, len(compressed))
-	out, err := snappy.Decode(dst, compressed)
-	if err != nil {
-		panic(err)
-	}
-	return out
+	return snappy.Decode(dst, compressed)
 }
 
 func ProtoUnmarshal(data []byte, obj proto.Message) error {
 	return proto.Unmarshal(data, obj)
 }
 
-func ReadFile(path string) []byte {
-	raw, err := ioutil.ReadFile(path)
-	if err != nil {
-		panic(err)
-	}
-	return raw
+func ReadFile(path string) ([]byte, error) {
+	return ioutil.ReadFile(path)
 }
 
-func ReadBz2File(path string) []byte {
+func ReadBz2File(path string) ([]byte, error) {
 	fd, err := os.Open(path)
 	if err != nil {
-		panic(err)
-	}
-	reader := bzip2.NewReader(fd)
-	raw, err := ioutil.ReadAll(reader)
-	if err != nil {
-		panic(err)
+		return nil, err
 	}
-	return raw
+	defer fd.Close()
+	return ioutil.ReadAll(bzip2.NewReader(fd))
 }
 
 const (
-	headerLength = 12
-	headerMagic  = "PBUFDEM"
+	headerLength     = 12
+	headerMagic      = "PBUFDEM"
+	readerBufferSize = 64 * 1024
+	// maxFrameSize is far more than any real frame needs, larger ones are
+	// corrupt and not worth allocating for.
+	maxFrameSize = 32 * 1024 * 1024
 )
 
 const (
@@ -65,6 +57,7 @@ type ItemType int
 type OuterParserBaseItem struct {
 	Sequence int64
 	Tick     int
+	Offset   int
 	From     dota.EDemoCommands
 	Object   proto.Message
 }
@@ -8,6 +8,7 @@ import (
 type OuterParserItem struct {
 	Sequence int64
 	Tick     int
+	Offset   int
 	Data     []byte
 	From     dota.EDemoCommands
 	Object   proto.Message
@@ -0,0 +1,132 @@
+package yasha
+
+import (
+	"io"
+	"sync"
+)
+
+// pipelineDepth is the number of frames the goroutines of AnalyzeConcurrently
+// may be ahead of the callback.
+const pipelineDepth = 64
+
+// rawFrame is a frame as read from the replay, still compressed.
+type rawFrame struct {
+	h    frameHeader
+	data []byte
+	err  error
+}
+
+// frameBatch holds the messages and diagnostics of one frame, in the order
+// they came up, and the error that ended the replay, if any.
+type frameBatch struct {
+	entries []batchEntry
+	err     error
+}
+
+// batchEntry is either an item or, if that's nil, a diagnostic.
+type batchEntry struct {
+	item       *OuterParserBaseItem
+	diagnostic Diagnostic
+}
+
+// AnalyzeConcurrently works like Analyze, but reads frames on one goroutine
+// and decompresses and unmarshals them on another, up to pipelineDepth frames
+// ahead. callback is still called for every message in order, from the
+// calling goroutine, and so is the Logger.
+func (p *OuterParser) AnalyzeConcurrently(callback func(*OuterParserBaseItem) error) error {
+	logger := p.Logger
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(2)
+	batches := p.decodeFrames(done, &wg, p.readFrames(done, &wg))
+	defer func() {
+		close(done)
+		wg.Wait()
+		p.Logger = logger
+	}()
+
+	for batch := range batches {
+		for _, entry := range batch.entries {
+			if entry.item == nil {
+				logger.Log(entry.diagnostic)
+				continue
+			}
+			if err := callback(entry.item); err != nil {
+				return err
+			}
+		}
+		if batch.err == io.EOF {
+			return nil
+		} else if batch.err != nil {
+			return batch.err
+		}
+	}
+	return nil
+}
+
+// readFrames reads frames until the replay ends or done is closed.
+func (p *OuterParser) readFrames(done <-chan struct{}, wg *sync.WaitGroup) <-chan rawFrame {
+	frames := make(chan rawFrame, pipelineDepth)
+	go func() {
+		defer wg.Done()
+		defer close(frames)
+		for {
+			h, err := p.readFrameHeader()
+			var data []byte
+			if err == nil {
+				// readFrameHeader rejects lengths above maxFrameSize.
+				data = make([]byte, h.length)
+				err = p.readFrame(h, data)
+			}
+			select {
+			case frames <- rawFrame{h: h, data: data, err: err}:
+			case <-done:
+				return
+			}
+			if err != nil {
+				return
+			}
+		}
+	}()
+	return frames
+}
+
+// decodeFrames turns frames into batches of messages, catching the
+// diagnostics of the OuterParser so they can be logged in order.
+func (p *OuterParser) decodeFrames(done <-chan struct{}, wg *sync.WaitGroup, frames <-chan rawFrame) <-chan frameBatch {
+	batches := make(chan frameBatch, pipelineDepth)
+
+	var batch frameBatch
+	if p.Logger != nil {
+		p.Logger = LoggerFunc(func(d Diagnostic) {
+			batch.entries = append(batch.entries, batchEntry{diagnostic: d})
+		})
+	}
+	collect := func(item *OuterParserBaseItem) error {
+		batch.entries = append(batch.entries, batchEntry{item: item})
+		return nil
+	}
+
+	go func() {
+		defer wg.Done()
+		defer close(batches)
+		for frame := range frames {
+			batch = frameBatch{err: frame.err}
+			if frame.err == nil {
+				// a panic here couldn't be recovered by the caller of Parse.
+				batch.err = decodeSafely(frame.h.tick, frame.h.offset, func() error {
+					return p.analyzeFrameData(collect, frame.h, frame.data)
+				})
+			}
+			select {
+			case batches <- batch:
+			case <-done:
+				return
+			}
+			if batch.err != nil {
+				return
+			}
+		}
+	}()
+	return batches
+}
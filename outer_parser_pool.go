@@ -0,0 +1,109 @@
+package yasha
+
+import (
+	"reflect"
+
+	"github.com/golang/protobuf/proto"
+
+	"github.com/dotabuff/yasha/dota"
+)
+
+// message kinds for the free lists, see newMessage.
+const (
+	messageNETSVC = iota << 16
+	messageBUMDUM
+)
+
+// newMessage returns an empty message for the id of kind, taken from the free
+// list of borrowed messages if there is one.
+func (p *OuterParser) newMessage(kind, id int) (proto.Message, error) {
+	key := kind | id
+	p.pool.Lock()
+	defer p.pool.Unlock()
+	if free := p.free[key]; len(free) > 0 {
+		msg := free[len(free)-1]
+		p.free[key] = free[:len(free)-1]
+		return msg, nil
+	}
+
+	var msg proto.Message
+	var err error
+	if kind == messageNETSVC {
+		msg, err = p.AsBaseEventNETSVC(id)
+	} else {
+		msg, err = p.AsBaseEventBUMDUM(id)
+	}
+	if err == nil && p.borrow {
+		if p.keys == nil {
+			p.free = map[int][]proto.Message{}
+			p.keys = map[reflect.Type]int{}
+		}
+		p.keys[reflect.TypeOf(msg)] = key
+	}
+	return msg, err
+}
+
+// releaseMessage resets a message returned by newMessage and puts it on the
+// free list, it must not be used anymore afterwards.
+func (p *OuterParser) releaseMessage(msg proto.Message) {
+	p.pool.Lock()
+	defer p.pool.Unlock()
+	if key, ok := p.keys[reflect.TypeOf(msg)]; ok {
+		msg.Reset()
+		p.free[key] = append(p.free[key], msg)
+	}
+}
+
+func (p *OuterParser) newItem() *OuterParserBaseItem {
+	p.pool.Lock()
+	defer p.pool.Unlock()
+	if n := len(p.items); n > 0 {
+		item := p.items[n-1]
+		p.items = p.items[:n-1]
+		return item
+	}
+	return &OuterParserBaseItem{}
+}
+
+// releaseItem puts item on the free list, it must not be used anymore
+// afterwards.
+func (p *OuterParser) releaseItem(item *OuterParserBaseItem) {
+	*item = OuterParserBaseItem{}
+	p.pool.Lock()
+	defer p.pool.Unlock()
+	p.items = append(p.items, item)
+}
+
+// buffer returns a byte slice of length n, reusing the memory of buf.
+func buffer(buf []byte, n int) []byte {
+	if cap(buf) < n {
+		return make([]byte, n)
+	}
+	return buf[:n]
+}
+
+// release returns the items of a processed tick and, when borrowing, their
+// messages to the free lists. Items the parser keeps around are left alone.
+func (p *OuterParser) release(items []*OuterParserBaseItem) {
+	for _, item := range items {
+		if retained(item.Object) {
+			continue
+		}
+		if p.borrow {
+			p.releaseMessage(item.Object)
+		}
+		p.releaseItem(item)
+	}
+}
+
+// retained reports whether the Parser holds on to obj (or its item) after the
+// tick, so it can't be reused.
+func retained(obj proto.Message) bool {
+	switch obj.(type) {
+	case *dota.CDemoFileHeader, *dota.CDemoFileInfo, *dota.CDemoClassInfo, *dota.CDemoStringTables,
+		*dota.CSVCMsg_ServerInfo, *dota.CSVCMsg_VoiceInit, *dota.CSVCMsg_GameEventList, *dota.CSVCMsg_SendTable,
+		*dota.CSVCMsg_CreateStringTable, *dota.CSVCMsg_UpdateStringTable:
+		return true
+	}
+	return false
+}
@@ -17,15 +17,30 @@ type PacketEntity struct {
 	EntityHandle int
 	Name         string
 	Type         UpdateType
+	Active       bool // false once the entity left the PVS or was deleted
 	Values       map[string]interface{}
-	Delta        map[string]interface{}
-	OldDelta     map[string]interface{}
+	// Fields holds the same values as Values, in the order of the props of the
+	// class in Parser.Mapping.
+	Fields []interface{}
+	// Delta and OldDelta hold the values changed by the last update and what
+	// they were before. With Parser.BorrowMessages they are reused by the next
+	// update of the entity.
+	Delta    map[string]interface{}
+	OldDelta map[string]interface{}
+
+	// bare prop names to their key in Values, see Get.
+	names map[string]string
 }
 
-const serialNumBits = 11
+// A handle is the index of the entity in its low bits, and the serial number
+// above them.
+const (
+	indexBits  = 11
+	serialBits = 10
+)
 
 func (pe *PacketEntity) Handle() int {
-	return pe.Index | (pe.SerialNum << serialNumBits)
+	return pe.Index | (pe.SerialNum << indexBits)
 }
 
 func (pe *PacketEntity) Clone() *PacketEntity {
@@ -41,7 +56,10 @@ func (pe *PacketEntity) Clone() *PacketEntity {
 		EntityHandle: pe.EntityHandle,
 		Name:         pe.Name,
 		Type:         pe.Type,
+		Active:       pe.Active,
 		Values:       values,
+		Fields:       append([]interface{}(nil), pe.Fields...),
+		names:        pe.names,
 	}
 }
 
@@ -59,3 +77,82 @@ func ReadUpdateType(br *BitReader) UpdateType {
 	}
 	return result
 }
+
+// invalidHandle is the value of handle props that don't point to an entity.
+const invalidHandle = 1<<(indexBits+serialBits) - 1
+
+// Get returns the value of a prop, either by its key like
+// "DT_DOTA_BaseNPC.m_iHealth", or just by its name like "m_iHealth". Names
+// declared by several tables of the class resolve to the most derived one.
+func (pe *PacketEntity) Get(name string) (interface{}, bool) {
+	value, ok := pe.Values[pe.key(name)]
+	return value, ok
+}
+
+func (pe *PacketEntity) key(name string) string {
+	if _, ok := pe.Values[name]; ok {
+		return name
+	}
+	if key, ok := pe.names[name]; ok {
+		return key
+	}
+	return name
+}
+
+// GetInt returns an integer prop, ok is false if it's missing or not an integer.
+func (pe *PacketEntity) GetInt(name string) (int, bool) {
+	value, _ := pe.Get(name)
+	return toInt(value)
+}
+
+// GetFloat returns a float prop, ok is false if it's missing or not a float.
+func (pe *PacketEntity) GetFloat(name string) (float64, bool) {
+	value, _ := pe.Get(name)
+	v, ok := value.(float64)
+	return v, ok
+}
+
+// GetVector3 returns a vector prop, VectorXY props are returned with a Z of 0.
+func (pe *PacketEntity) GetVector3(name string) (Vector3, bool) {
+	value, _ := pe.Get(name)
+	return toVector3(value)
+}
+
+// GetString returns a string prop, ok is false if it's missing or not a string.
+func (pe *PacketEntity) GetString(name string) (string, bool) {
+	value, _ := pe.Get(name)
+	v, ok := value.(string)
+	return v, ok
+}
+
+// GetHandle returns an entity handle prop like "m_hOwnerEntity", which can be
+// looked up in Parser.ByHandle. ok is false if it doesn't point to an entity.
+func (pe *PacketEntity) GetHandle(name string) (int, bool) {
+	v, ok := pe.GetInt(name)
+	if !ok || v == invalidHandle {
+		return 0, false
+	}
+	return v, true
+}
+
+func toInt(value interface{}) (int, bool) {
+	switch v := value.(type) {
+	case int:
+		return v, true
+	case uint:
+		return int(v), true
+	case uint64:
+		return int(v), true
+	}
+	return 0, false
+}
+
+func toVector3(value interface{}) (Vector3, bool) {
+	switch v := value.(type) {
+	case *Vector3:
+		return *v, true
+	case *Vector2:
+		return Vector3{X: v.X, Y: v.Y}, true
+	}
+	return Vector3{}, false
+}
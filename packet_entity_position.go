@@ -0,0 +1,64 @@
+package yasha
+
+// maxCoordinate is the offset of cell coordinates, the center of the map is at
+// cell 1<<(14-cellbits).
+const maxCoordinate = 16384
+
+// Position returns the location of the entity on the map, computed from its
+// cell and the origin within the cell. ok is false for entities that don't
+// have a position, like DT_DOTAGamerulesProxy.
+func (pe *PacketEntity) Position() (Vector3, bool) {
+	return pe.position(false)
+}
+
+// PositionDelta returns how far the entity moved with its last update, which
+// is useful in OnEntityPreserved. ok is false if it didn't move.
+func (pe *PacketEntity) PositionDelta() (Vector3, bool) {
+	if pe.Type != Preserve || len(pe.OldDelta) == 0 {
+		return Vector3{}, false
+	}
+
+	from, ok := pe.position(true)
+	if !ok {
+		return Vector3{}, false
+	}
+	to, _ := pe.position(false)
+	delta := Vector3{X: to.X - from.X, Y: to.Y - from.Y, Z: to.Z - from.Z}
+	return delta, delta != Vector3{}
+}
+
+// position computes the location from the current values, or from what they
+// were before the last update if old is set.
+func (pe *PacketEntity) position(old bool) (Vector3, bool) {
+	value := func(name string) interface{} {
+		key := pe.key(name)
+		if v, ok := pe.OldDelta[key]; ok && old {
+			return v
+		}
+		return pe.Values[key]
+	}
+
+	cellbits, ok := toInt(value("m_cellbits"))
+	if !ok {
+		return Vector3{}, false
+	}
+	cellWidth := float64(int(1) << uint(cellbits))
+	origin, ok := toVector3(value("m_vecOrigin"))
+	if !ok {
+		return Vector3{}, false
+	}
+	cellX, okX := toInt(value("m_cellX"))
+	cellY, okY := toInt(value("m_cellY"))
+	if !okX || !okY {
+		return Vector3{}, false
+	}
+	pos := Vector3{
+		X: float64(cellX)*cellWidth - maxCoordinate + origin.X,
+		Y: float64(cellY)*cellWidth - maxCoordinate + origin.Y,
+		Z: origin.Z,
+	}
+	if cellZ, ok := toInt(value("m_cellZ")); ok {
+		pos.Z += float64(cellZ)*cellWidth - maxCoordinate
+	}
+	return pos, true
+}
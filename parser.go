@@ -1,8 +1,14 @@
 package yasha
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
 	"math"
+	"reflect"
 	"sort"
+	"strconv"
 	"strings"
 
 	"github.com/davecgh/go-spew/spew"
@@ -19,92 +25,246 @@ type Parser struct {
 	FileHeader            *dota.CDemoFileHeader
 	GameEventMap          map[int32]*dota.CSVCMsg_GameEventListDescriptorT
 	Mapping               map[int][]*SendProp
-	Multiples             map[int]map[string]int
+	Multiples             map[int]map[string]int // per class, how often every DtName.VarName occurs
+	PropNames             map[int]map[string]string
 	ServerInfo            *dota.CSVCMsg_ServerInfo
 	Sth                   *Helper
 	Stsh                  *StateHelper
 	VoiceInit             *dota.CSVCMsg_VoiceInit
 
+	// Logger receives diagnostics about data that couldn't be handled, it's
+	// silent by default.
+	Logger Logger
+
+	// BorrowMessages reuses the messages passed to the On* callbacks once their
+	// tick is processed, which saves most allocations. They must not be kept
+	// after the callback returns, use proto.Clone for that. The same goes for
+	// the Delta and OldDelta maps of entities.
+	BorrowMessages bool
+
+	// Concurrent reads, decompresses and unmarshals frames on goroutines of
+	// their own, ahead of the callbacks, which are still called in order from
+	// the goroutine calling Parse. It has no effect on SeekToTick.
+	Concurrent bool
+
 	ActiveModifiers map[int]*dota.CDOTAModifierBuffTableEntry
 	Entities        []*PacketEntity
 	ByHandle        map[int]*PacketEntity
 
-	OnEntityCreated   func(*PacketEntity)
-	OnEntityDeleted   func(*PacketEntity)
-	OnEntityPreserved func(*PacketEntity)
-
-	OnActiveModifierDelta func(map[int]*StringTableItem, ModifierBuffs)
-
-	OnAbilitySteal              func(tick int, obj *dota.CDOTAUserMsg_AbilitySteal)
-	OnBoosterState              func(tick int, obj *dota.CDOTAUserMsg_BoosterState)
-	OnBotChat                   func(tick int, obj *dota.CDOTAUserMsg_BotChat)
-	OnChatEvent                 func(tick int, obj *dota.CDOTAUserMsg_ChatEvent)
-	OnChatWheel                 func(tick int, obj *dota.CDOTAUserMsg_ChatWheel)
-	OnClassInfo                 func(tick int, obj *dota.CSVCMsg_ClassInfo)
-	OnCourierKilledAlert        func(tick int, obj *dota.CDOTAUserMsg_CourierKilledAlert)
-	OnCreateLinearProjectile    func(tick int, obj *dota.CDOTAUserMsg_CreateLinearProjectile)
-	OnDemoStop                  func(tick int, obj *dota.CDemoStop)
-	OnDemoSyncTick              func(tick int, obj *dota.CDemoSyncTick)
-	OnDestroyLinearProjectile   func(tick int, obj *dota.CDOTAUserMsg_DestroyLinearProjectile)
-	OnDodgeTrackingProjectiles  func(tick int, obj *dota.CDOTAUserMsg_DodgeTrackingProjectiles)
-	OnEnemyItemAlert            func(tick int, obj *dota.CDOTAUserMsg_EnemyItemAlert)
-	OnGlobalLightColor          func(tick int, obj *dota.CDOTAUserMsg_GlobalLightColor)
-	OnGlobalLightDirection      func(tick int, obj *dota.CDOTAUserMsg_GlobalLightDirection)
-	OnHPManaAlert               func(tick int, obj *dota.CDOTAUserMsg_HPManaAlert)
-	OnHalloweenDrops            func(tick int, obj *dota.CDOTAUserMsg_HalloweenDrops)
-	OnHudError                  func(tick int, obj *dota.CDOTAUserMsg_HudError)
-	OnLocationPing              func(tick int, obj *dota.CDOTAUserMsg_LocationPing)
-	OnMapLine                   func(tick int, obj *dota.CDOTAUserMsg_MapLine)
-	OnMinimapEvent              func(tick int, obj *dota.CDOTAUserMsg_MinimapEvent)
-	OnNevermoreRequiem          func(tick int, obj *dota.CDOTAUserMsg_NevermoreRequiem)
-	OnOverheadEvent             func(tick int, obj *dota.CDOTAUserMsg_OverheadEvent)
-	OnParticleManager           func(tick int, obj *dota.CDOTAUserMsg_ParticleManager)
-	OnPredictionResult          func(tick int, obj *dota.CDOTAUserMsg_PredictionResult)
-	OnPrint                     func(tick int, obj *dota.CSVCMsg_Print)
-	OnSayText2                  func(tick int, obj *dota.CUserMsg_SayText2)
-	OnSendAudio                 func(tick int, obj *dota.CUserMsg_SendAudio)
-	OnSendRoshanPopup           func(tick int, obj *dota.CDOTAUserMsg_SendRoshanPopup)
-	OnSendStatPopup             func(tick int, obj *dota.CDOTAUserMsg_SendStatPopup)
-	OnSetView                   func(tick int, obj *dota.CSVCMsg_SetView)
-	OnSharedCooldown            func(tick int, obj *dota.CDOTAUserMsg_SharedCooldown)
-	OnSignonState               func(tick int, obj *dota.CNETMsg_SignonState)
-	OnSounds                    func(tick int, obj *dota.CSVCMsg_Sounds)
-	OnSpectatorPlayerClick      func(tick int, obj *dota.CDOTAUserMsg_SpectatorPlayerClick)
-	OnSpectatorPlayerUnitOrders func(tick int, obj *dota.CDOTAUserMsg_SpectatorPlayerUnitOrders)
-	OnTempEntities              func(tick int, obj *dota.CSVCMsg_TempEntities)
-	OnTextMsg                   func(tick int, obj *dota.CUserMsg_TextMsg)
-	OnTick                      func(tick int, obj *dota.CNETMsg_Tick)
-	OnUnitEvent                 func(tick int, obj *dota.CDOTAUserMsg_UnitEvent)
-	OnVoiceMask                 func(tick int, obj *dota.CUserMsg_VoiceMask)
-	OnWorldLine                 func(tick int, obj *dota.CDOTAUserMsg_WorldLine)
-
-	OnFileInfo  func(obj *dota.CDemoFileInfo)
-	OnSetConVar func(obj *dota.CNETMsg_SetConVar)
-	OnVoiceData func(obj *dota.CSVCMsg_VoiceData)
-
-	OnCombatLog func(tick int, log CombatLogEntry)
-
-	OnTablename func(name string)
-
-	BeforeTick func(tick int)
-	AfterTick  func(tick int)
+	OnEntityCreated   func(*PacketEntity) error
+	OnEntityDeleted   func(*PacketEntity) error
+	OnEntityPreserved func(*PacketEntity) error
+	// OnEntityLeft is called when an entity leaves the PVS, it stays in Entities
+	// and ByHandle, but isn't Active until it's created again or deleted.
+	OnEntityLeft func(*PacketEntity) error
+
+	OnActiveModifierDelta func(map[int]*StringTableItem, ModifierBuffs) error
+
+	OnAbilitySteal              func(tick int, obj *dota.CDOTAUserMsg_AbilitySteal) error
+	OnBoosterState              func(tick int, obj *dota.CDOTAUserMsg_BoosterState) error
+	OnBotChat                   func(tick int, obj *dota.CDOTAUserMsg_BotChat) error
+	OnChatEvent                 func(tick int, obj *dota.CDOTAUserMsg_ChatEvent) error
+	OnChatWheel                 func(tick int, obj *dota.CDOTAUserMsg_ChatWheel) error
+	OnClassInfo                 func(tick int, obj *dota.CSVCMsg_ClassInfo) error
+	OnCourierKilledAlert        func(tick int, obj *dota.CDOTAUserMsg_CourierKilledAlert) error
+	OnCreateLinearProjectile    func(tick int, obj *dota.CDOTAUserMsg_CreateLinearProjectile) error
+	OnDemoStop                  func(tick int, obj *dota.CDemoStop) error
+	OnDemoSyncTick              func(tick int, obj *dota.CDemoSyncTick) error
+	OnDestroyLinearProjectile   func(tick int, obj *dota.CDOTAUserMsg_DestroyLinearProjectile) error
+	OnDodgeTrackingProjectiles  func(tick int, obj *dota.CDOTAUserMsg_DodgeTrackingProjectiles) error
+	OnEnemyItemAlert            func(tick int, obj *dota.CDOTAUserMsg_EnemyItemAlert) error
+	OnGlobalLightColor          func(tick int, obj *dota.CDOTAUserMsg_GlobalLightColor) error
+	OnGlobalLightDirection      func(tick int, obj *dota.CDOTAUserMsg_GlobalLightDirection) error
+	OnHPManaAlert               func(tick int, obj *dota.CDOTAUserMsg_HPManaAlert) error
+	OnHalloweenDrops            func(tick int, obj *dota.CDOTAUserMsg_HalloweenDrops) error
+	OnHudError                  func(tick int, obj *dota.CDOTAUserMsg_HudError) error
+	OnLocationPing              func(tick int, obj *dota.CDOTAUserMsg_LocationPing) error
+	OnMapLine                   func(tick int, obj *dota.CDOTAUserMsg_MapLine) error
+	OnMinimapEvent              func(tick int, obj *dota.CDOTAUserMsg_MinimapEvent) error
+	OnNevermoreRequiem          func(tick int, obj *dota.CDOTAUserMsg_NevermoreRequiem) error
+	OnOverheadEvent             func(tick int, obj *dota.CDOTAUserMsg_OverheadEvent) error
+	OnParticleManager           func(tick int, obj *dota.CDOTAUserMsg_ParticleManager) error
+	OnPredictionResult          func(tick int, obj *dota.CDOTAUserMsg_PredictionResult) error
+	OnPrint                     func(tick int, obj *dota.CSVCMsg_Print) error
+	OnSayText2                  func(tick int, obj *dota.CUserMsg_SayText2) error
+	OnSendAudio                 func(tick int, obj *dota.CUserMsg_SendAudio) error
+	OnSendRoshanPopup           func(tick int, obj *dota.CDOTAUserMsg_SendRoshanPopup) error
+	OnSendStatPopup             func(tick int, obj *dota.CDOTAUserMsg_SendStatPopup) error
+	OnSetView                   func(tick int, obj *dota.CSVCMsg_SetView) error
+	OnSharedCooldown            func(tick int, obj *dota.CDOTAUserMsg_SharedCooldown) error
+	OnSignonState               func(tick int, obj *dota.CNETMsg_SignonState) error
+	OnSounds                    func(tick int, obj *dota.CSVCMsg_Sounds) error
+	OnSpectatorPlayerClick      func(tick int, obj *dota.CDOTAUserMsg_SpectatorPlayerClick) error
+	OnSpectatorPlayerUnitOrders func(tick int, obj *dota.CDOTAUserMsg_SpectatorPlayerUnitOrders) error
+	OnTempEntities              func(tick int, obj *dota.CSVCMsg_TempEntities) error
+	OnTextMsg                   func(tick int, obj *dota.CUserMsg_TextMsg) error
+	OnTick                      func(tick int, obj *dota.CNETMsg_Tick) error
+	OnUnitEvent                 func(tick int, obj *dota.CDOTAUserMsg_UnitEvent) error
+	OnVoiceMask                 func(tick int, obj *dota.CUserMsg_VoiceMask) error
+	OnWorldLine                 func(tick int, obj *dota.CDOTAUserMsg_WorldLine) error
+
+	OnFileInfo  func(obj *dota.CDemoFileInfo) error
+	OnSetConVar func(obj *dota.CNETMsg_SetConVar) error
+	OnVoiceData func(obj *dota.CSVCMsg_VoiceData) error
+
+	OnCombatLog func(tick int, log CombatLogEntry) error
+	OnItem      func(event *ItemEvent) error
+	OnGameEvent GameEventHandler
+
+	OnAbilityLevelUp func(ability *AbilityTracker) error
+	OnAbilityCast    func(cast *AbilityCast) error
+	OnKill           func(kill *Kill) error
+	OnTeamfight      func(fight *Teamfight) error
+	OnWardPlaced     func(ward *Ward) error
+	OnWardRemoved    func(ward *Ward) error
+
+	OnTablename func(name string) error
+
+	BeforeTick func(tick int) error
+	AfterTick  func(tick int) error
+
+	// OnPause and OnUnpause are called when the game is paused or resumed, see
+	// also Clock.
+	OnPause   func(tick int) error
+	OnUnpause func(tick int) error
+
+	// items of the tick that is currently being read.
+	tick    int
+	pending []*OuterParserBaseItem
+
+	// set while SeekToTick replays frames without calling back.
+	seeking     bool
+	restoreTick int
+
+	// compiled from Mapping, and scratch space for the prop indices.
+	decoders      map[int]*classDecoder
+	indices       []int
+	arrayKeyCache map[arrayKey][]string
+
+	// entities with the state of the game, see trackEntity.
+	playerResource *PacketEntity
+	gameRules      *PacketEntity
+
+	// see Match, playerKeys are the keys of the player resource props per slot.
+	match      *MatchSummary
+	playerKeys map[string][]string
+	timeline   *Timeline
+	clock      *GameClock
+	inventory  *Inventory
+	abilities  *AbilityBook
+	kills      *KillFeed
+	teamfights *Teamfights
+	wards      *Wards
+
+	handlers          map[reflect.Type][]MessageHandler
+	gameEventHandlers map[string][]GameEventHandler
 }
 
-func ParserFromFile(path string) *Parser {
-	if strings.HasSuffix(path, ".dem.bz2") {
-		return NewParser(ReadBz2File(path))
-	} else if strings.HasSuffix(path, ".dem") {
-		return NewParser(ReadFile(path))
-	} else {
-		panic("expected path to .dem or .dem.bz2 instead of " + path)
+// ParserFromFile opens a .dem or .dem.bz2 replay, which is read as the parser
+// goes along. Close the parser once done with it, the file stays open so
+// SeekToTick still works after Parse.
+func ParserFromFile(path string) (*Parser, error) {
+	outer, err := OuterParserFromFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return &Parser{Parser: outer}, nil
+}
+
+func NewParser(data []byte) (*Parser, error) {
+	outer, err := NewOuterParser(data)
+	if err != nil {
+		return nil, err
+	}
+	return &Parser{Parser: outer}, nil
+}
+
+// NewParserFromReader parses the replay straight from r, for example an HTTP
+// response body, without loading it into memory first. Wrap r with
+// bzip2.NewReader for .dem.bz2 replays.
+func NewParserFromReader(r io.Reader) (*Parser, error) {
+	outer, err := NewOuterParserFromReader(r)
+	if err != nil {
+		return nil, err
+	}
+	return &Parser{Parser: outer}, nil
+}
+
+// Close releases the replay file opened by ParserFromFile.
+func (p *Parser) Close() error {
+	return p.Parser.Close()
+}
+
+// Parse processes the whole replay, calling the On* callbacks along the way.
+// It returns a *ParseError if the replay is corrupt or truncated.
+func (p *Parser) Parse() error {
+	return p.ParseContext(context.Background())
+}
+
+// ParseContext is like Parse, but stops at the next tick boundary once ctx is
+// done, returning ctx.Err(). Any error returned by a callback also stops
+// parsing and is returned as is, except for ErrStopParsing, which ends it
+// early without an error.
+func (p *Parser) ParseContext(ctx context.Context) error {
+	err := p.parse(ctx)
+	if errors.Is(err, ErrStopParsing) {
+		return nil
+	}
+	return err
+}
+
+func (p *Parser) parse(ctx context.Context) error {
+	p.setup()
+
+	analyze := p.Parser.Analyze
+	if p.Concurrent {
+		analyze = p.Parser.AnalyzeConcurrently
+	}
+	err := analyze(func(item *OuterParserBaseItem) error {
+		if item.Tick > p.tick {
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			default:
+			}
+		}
+		return p.collect(item)
+	})
+	stopped := errors.Is(err, ErrStopParsing) || (ctx.Err() != nil && err == ctx.Err())
+	if err != nil && !stopped {
+		return err
+	}
+
+	// and process all remaining in the last tick
+	if err == nil {
+		p.tick++
+		items := p.pending
+		p.pending = nil
+		if err := p.processTick(p.tick, items); err != nil {
+			return err
+		}
+	}
+
+	// a fight that's still going on ends with the replay, or when parsing is
+	// stopped.
+	if p.teamfights != nil {
+		if err := p.teamfights.close(p); err != nil {
+			return err
+		}
 	}
+	return err
 }
 
-func NewParser(data []byte) *Parser {
-	return &Parser{Parser: NewOuterParser(data)}
+func (p *Parser) setup() {
+	if p.Sth == nil {
+		p.init()
+	}
+	p.Parser.Logger = p.Logger
+	p.Parser.borrow = p.BorrowMessages
+	p.combatLogParser.logger = p.Logger
 }
 
-func (p *Parser) Parse() {
+func (p *Parser) init() {
 	p.Sth = NewSendTablesHelper()
 	p.Stsh = NewStateHelper()
 	p.Entities = make([]*PacketEntity, 2048)
@@ -113,39 +273,41 @@ func (p *Parser) Parse() {
 	p.GameEventMap = map[int32]*dota.CSVCMsg_GameEventListDescriptorT{}
 	p.Mapping = map[int][]*SendProp{}
 	p.Multiples = map[int]map[string]int{}
+	p.PropNames = map[int]map[string]string{}
+	p.decoders = map[int]*classDecoder{}
 	p.ByHandle = map[int]*PacketEntity{}
+	p.playerResource, p.gameRules = nil, nil
 	p.combatLogParser = &combatLogParser{
 		stsh:     p.Stsh,
 		distinct: map[dota.DOTA_COMBATLOG_TYPES][]map[interface{}]bool{},
+		logger:   p.Logger,
 	}
+	p.tick = 0
+	p.pending = nil
+	p.restoreTick = -1
+}
 
-	// in order to successfully process data every tick, we need to maintain
-	// order.  First of all the string and send tables for the tick have to be
-	// done, then everything else.  But to also maintain streaming behaviour, we
-	// simply stuff all data for one tick into a buffer, and iterate it twice.
-	//
-	// A bit of a waste of iterations because it still needs an expensive type
-	// switch, but less overhead than doing a sort instead.
-
-	currentTick := 0
-	buffer := []*OuterParserBaseItem{}
-
-	p.Parser.Analyze(func(item *OuterParserBaseItem) {
-		// we got a new tick, process the previous items
-		if item.Tick > currentTick {
-			p.processTick(currentTick, buffer)
-			buffer = make([]*OuterParserBaseItem, 1, len(buffer))
-			buffer[0] = item
-			currentTick = item.Tick
-		} else {
-			// tick is ongoing
-			buffer = append(buffer, item)
-		}
-	})
+// in order to successfully process data every tick, we need to maintain
+// order.  First of all the string and send tables for the tick have to be
+// done, then everything else.  But to also maintain streaming behaviour, we
+// simply stuff all data for one tick into a buffer, and iterate it twice.
+//
+// A bit of a waste of iterations because it still needs an expensive type
+// switch, but less overhead than doing a sort instead.
+func (p *Parser) collect(item *OuterParserBaseItem) error {
+	// tick is ongoing
+	if item.Tick <= p.tick {
+		p.pending = append(p.pending, item)
+		return nil
+	}
 
-	// and process all remaining in the last tick
-	currentTick++
-	p.processTick(currentTick, buffer)
+	// we got a new tick, process the previous items
+	if err := p.processTick(p.tick, p.pending); err != nil {
+		return err
+	}
+	p.pending = append(p.pending[:0], item)
+	p.tick = item.Tick
+	return nil
 }
 
 func (p *Parser) PrintDistinctCombatLogTypes() {
@@ -160,11 +322,14 @@ func (p *Parser) PrintDistinctCombatLogTypes() {
 	}
 }
 
-func (p *Parser) processTick(tick int, items []*OuterParserBaseItem) {
+func (p *Parser) processTick(tick int, items []*OuterParserBaseItem) error {
+	defer p.Parser.release(items)
 	p.Stsh.ActiveModifierDelta = ModifierBuffs{}
 
-	if p.BeforeTick != nil {
-		p.BeforeTick(tick)
+	if p.BeforeTick != nil && !p.seeking {
+		if err := p.BeforeTick(tick); err != nil {
+			return err
+		}
 	}
 
 	for _, item := range items {
@@ -173,16 +338,37 @@ func (p *Parser) processTick(tick int, items []*OuterParserBaseItem) {
 			p.Sth.SetSendTable(obj.GetNetTableName(), obj)
 		case *dota.CSVCMsg_ServerInfo:
 			p.ServerInfo = obj
+			if interval := obj.GetTickInterval(); interval > 0 {
+				p.Clock().TickInterval = float64(interval)
+			}
 			p.ClassIdNumBits = int(math.Log(float64(obj.GetMaxClasses()))/math.Log(2)) + 1
 		case *dota.CDemoClassInfo:
-			p.onCDemoClassInfo(obj)
+			if err := p.onCDemoClassInfo(obj); err != nil {
+				return err
+			}
 		}
 	}
 
 	for _, item := range items {
 		switch obj := item.Object.(type) {
-		case *dota.CSVCMsg_CreateStringTable, *dota.CSVCMsg_UpdateStringTable, *dota.CDemoStringTables:
-			p.Stsh.AppendPacket(item)
+		case *dota.CSVCMsg_CreateStringTable, *dota.CSVCMsg_UpdateStringTable:
+			err := decodeSafely(item.Tick, item.Offset, func() error {
+				return p.Stsh.AppendPacket(item)
+			})
+			if err != nil {
+				return err
+			}
+		case *dota.CDemoStringTables:
+			// these only repeat what we got from CST/UST, unless we just seeked to
+			// their DEM_FullPacket.
+			if item.From == dota.EDemoCommands_DEM_FullPacket && item.Tick == p.restoreTick {
+				err := decodeSafely(item.Tick, item.Offset, func() error {
+					return locate(p.Stsh.OnCDST(item.Tick, obj), item.Tick, item.Offset)
+				})
+				if err != nil {
+					return err
+				}
+			}
 		case *dota.CDemoFileHeader:
 			p.FileHeader = obj
 		case *dota.CSVCMsg_GameEventList:
@@ -193,6 +379,19 @@ func (p *Parser) processTick(tick int, items []*OuterParserBaseItem) {
 	}
 
 	for _, item := range items {
+		if p.seeking {
+			switch obj := item.Object.(type) {
+			case *dota.CSVCMsg_PacketEntities:
+				if err := p.onPacketEntities(item, obj); err != nil {
+					return err
+				}
+			case *dota.CSVCMsg_SetPause:
+				p.Clock().setPaused(item.Tick, obj.GetPaused())
+			}
+			continue
+		}
+
+		var err error
 		switch obj := item.Object.(type) {
 		case *dota.CDemoClassInfo,
 			*dota.CDemoFileHeader,
@@ -205,264 +404,332 @@ func (p *Parser) processTick(tick int, items []*OuterParserBaseItem) {
 			// those have been handled above, please keep in sync.
 		case *dota.CDemoStop:
 			if p.OnDemoStop != nil {
-				p.OnDemoStop(item.Tick, obj)
+				err = p.OnDemoStop(item.Tick, obj)
 			}
 		case *dota.CDemoSyncTick:
 			if p.OnDemoSyncTick != nil {
-				p.OnDemoSyncTick(item.Tick, obj)
+				err = p.OnDemoSyncTick(item.Tick, obj)
 			}
 		case *dota.CDOTAUserMsg_BoosterState:
 			if p.OnBoosterState != nil {
-				p.OnBoosterState(item.Tick, obj)
+				err = p.OnBoosterState(item.Tick, obj)
 			}
 		case *dota.CDOTAUserMsg_CourierKilledAlert:
 			if p.OnCourierKilledAlert != nil {
-				p.OnCourierKilledAlert(item.Tick, obj)
+				err = p.OnCourierKilledAlert(item.Tick, obj)
 			}
 		case *dota.CDOTAUserMsg_CreateLinearProjectile:
 			if p.OnCreateLinearProjectile != nil {
-				p.OnCreateLinearProjectile(item.Tick, obj)
+				err = p.OnCreateLinearProjectile(item.Tick, obj)
 			}
 		case *dota.CDOTAUserMsg_DestroyLinearProjectile:
 			if p.OnDestroyLinearProjectile != nil {
-				p.OnDestroyLinearProjectile(item.Tick, obj)
+				err = p.OnDestroyLinearProjectile(item.Tick, obj)
 			}
 		case *dota.CDOTAUserMsg_DodgeTrackingProjectiles:
 			if p.OnDodgeTrackingProjectiles != nil {
-				p.OnDodgeTrackingProjectiles(item.Tick, obj)
+				err = p.OnDodgeTrackingProjectiles(item.Tick, obj)
 			}
 		case *dota.CDOTAUserMsg_GlobalLightColor:
 			if p.OnGlobalLightColor != nil {
-				p.OnGlobalLightColor(item.Tick, obj)
+				err = p.OnGlobalLightColor(item.Tick, obj)
 			}
 		case *dota.CDOTAUserMsg_GlobalLightDirection:
 			if p.OnGlobalLightDirection != nil {
-				p.OnGlobalLightDirection(item.Tick, obj)
+				err = p.OnGlobalLightDirection(item.Tick, obj)
 			}
 		case *dota.CDOTAUserMsg_HalloweenDrops:
 			if p.OnHalloweenDrops != nil {
-				p.OnHalloweenDrops(item.Tick, obj)
+				err = p.OnHalloweenDrops(item.Tick, obj)
 			}
 		case *dota.CDOTAUserMsg_HudError:
 			if p.OnHudError != nil {
-				p.OnHudError(item.Tick, obj)
+				err = p.OnHudError(item.Tick, obj)
 			}
 		case *dota.CDOTAUserMsg_LocationPing:
 			if p.OnLocationPing != nil {
-				p.OnLocationPing(item.Tick, obj)
+				err = p.OnLocationPing(item.Tick, obj)
 			}
 		case *dota.CDOTAUserMsg_MapLine:
 			if p.OnMapLine != nil {
-				p.OnMapLine(item.Tick, obj)
+				err = p.OnMapLine(item.Tick, obj)
 			}
 		case *dota.CDOTAUserMsg_MinimapEvent:
 			if p.OnMinimapEvent != nil {
-				p.OnMinimapEvent(item.Tick, obj)
+				err = p.OnMinimapEvent(item.Tick, obj)
 			}
 		case *dota.CDOTAUserMsg_NevermoreRequiem:
 			if p.OnNevermoreRequiem != nil {
-				p.OnNevermoreRequiem(item.Tick, obj)
+				err = p.OnNevermoreRequiem(item.Tick, obj)
 			}
 		case *dota.CDOTAUserMsg_ParticleManager:
 			if p.OnParticleManager != nil {
-				p.OnParticleManager(item.Tick, obj)
+				err = p.OnParticleManager(item.Tick, obj)
 			}
 		case *dota.CDOTAUserMsg_SendRoshanPopup:
 			if p.OnSendRoshanPopup != nil {
-				p.OnSendRoshanPopup(item.Tick, obj)
+				err = p.OnSendRoshanPopup(item.Tick, obj)
 			}
 		case *dota.CDOTAUserMsg_SendStatPopup:
 			if p.OnSendStatPopup != nil {
-				p.OnSendStatPopup(item.Tick, obj)
+				err = p.OnSendStatPopup(item.Tick, obj)
 			}
 		case *dota.CDOTAUserMsg_SharedCooldown:
 			if p.OnSharedCooldown != nil {
-				p.OnSharedCooldown(item.Tick, obj)
+				err = p.OnSharedCooldown(item.Tick, obj)
 			}
 		case *dota.CDOTAUserMsg_UnitEvent:
 			if p.OnUnitEvent != nil {
-				p.OnUnitEvent(item.Tick, obj)
+				err = p.OnUnitEvent(item.Tick, obj)
 			}
 		case *dota.CDOTAUserMsg_WorldLine:
 			if p.OnWorldLine != nil {
-				p.OnWorldLine(item.Tick, obj)
+				err = p.OnWorldLine(item.Tick, obj)
 			}
 		case *dota.CNETMsg_SignonState:
 			if p.OnSignonState != nil {
-				p.OnSignonState(item.Tick, obj)
+				err = p.OnSignonState(item.Tick, obj)
 			}
 		case *dota.CNETMsg_Tick:
 			if p.OnTick != nil {
-				p.OnTick(item.Tick, obj)
+				err = p.OnTick(item.Tick, obj)
 			}
 		case *dota.CSVCMsg_ClassInfo:
 			if p.OnClassInfo != nil {
-				p.OnClassInfo(item.Tick, obj)
+				err = p.OnClassInfo(item.Tick, obj)
 			}
 		case *dota.CSVCMsg_Print:
 			if p.OnPrint != nil {
-				p.OnPrint(item.Tick, obj)
+				err = p.OnPrint(item.Tick, obj)
 			}
 		case *dota.CSVCMsg_SetView:
 			if p.OnSetView != nil {
-				p.OnSetView(item.Tick, obj)
+				err = p.OnSetView(item.Tick, obj)
 			}
 		case *dota.CSVCMsg_TempEntities:
 			if p.OnTempEntities != nil {
-				p.OnTempEntities(item.Tick, obj)
+				err = p.OnTempEntities(item.Tick, obj)
 			}
 		case *dota.CUserMsg_SendAudio:
 			if p.OnSendAudio != nil {
-				p.OnSendAudio(item.Tick, obj)
+				err = p.OnSendAudio(item.Tick, obj)
 			}
 		case *dota.CUserMsg_TextMsg:
 			if p.OnTextMsg != nil {
-				p.OnTextMsg(item.Tick, obj)
+				err = p.OnTextMsg(item.Tick, obj)
 			}
 		case *dota.CUserMsg_VoiceMask:
 			if p.OnVoiceMask != nil {
-				p.OnVoiceMask(item.Tick, obj)
+				err = p.OnVoiceMask(item.Tick, obj)
 			}
 		case *dota.CSVCMsg_PacketEntities:
-			// to skip to a specific time, we have to handle more.
-			if item.From == dota.EDemoCommands_DEM_Packet {
-				p.ParsePacket(item.Tick, obj)
+			if err := p.onPacketEntities(item, obj); err != nil {
+				return err
 			}
+		case *dota.CSVCMsg_SetPause:
+			err = p.setPaused(item.Tick, obj.GetPaused())
 		case *dota.CDemoFileInfo:
+			p.updateMatch(obj)
 			if p.OnFileInfo != nil {
-				p.OnFileInfo(obj)
+				err = p.OnFileInfo(obj)
 			}
 		case *dota.CSVCMsg_VoiceInit:
 			p.VoiceInit = obj
 		case *dota.CSVCMsg_GameEvent:
-			p.onGameEvent(item.Tick, obj)
+			if err := p.onGameEvent(item.Tick, obj); err != nil {
+				return locate(err, item.Tick, item.Offset)
+			}
 		case *dota.CDOTAUserMsg_ChatEvent:
 			if p.OnChatEvent != nil {
-				p.OnChatEvent(item.Tick, obj)
+				err = p.OnChatEvent(item.Tick, obj)
 			}
 		case *dota.CDOTAUserMsg_OverheadEvent:
 			if p.OnOverheadEvent != nil {
-				p.OnOverheadEvent(item.Tick, obj)
+				err = p.OnOverheadEvent(item.Tick, obj)
 			}
 		case *dota.CDOTAUserMsg_SpectatorPlayerClick:
 			if p.OnSpectatorPlayerClick != nil {
-				p.OnSpectatorPlayerClick(item.Tick, obj)
+				err = p.OnSpectatorPlayerClick(item.Tick, obj)
 			}
 		case *dota.CUserMsg_SayText2:
 			if p.OnSayText2 != nil {
-				p.OnSayText2(item.Tick, obj)
+				err = p.OnSayText2(item.Tick, obj)
 			}
 		case *dota.CSVCMsg_Sounds:
 			if p.OnSounds != nil {
-				p.OnSounds(item.Tick, obj)
+				err = p.OnSounds(item.Tick, obj)
 			}
 		case *dota.CSVCMsg_VoiceData:
 			if p.OnVoiceData != nil {
-				p.OnVoiceData(obj)
+				err = p.OnVoiceData(obj)
 			}
 		case *dota.CNETMsg_SetConVar:
 			if p.OnSetConVar != nil {
-				p.OnSetConVar(obj)
+				err = p.OnSetConVar(obj)
 			}
 		case *dota.CDOTAUserMsg_ChatWheel:
 			// (chat_message:k_EDOTA_CW_All_GGWP player_id:2 param_hero_id:0 )
 			if p.OnChatWheel != nil {
-				p.OnChatWheel(item.Tick, obj)
+				err = p.OnChatWheel(item.Tick, obj)
 			}
 		case *dota.CDOTAUserMsg_EnemyItemAlert:
 			// (player_id:13 target_player_id:9 itemid:751 rune_type:4294967295 )
 			if p.OnEnemyItemAlert != nil {
-				p.OnEnemyItemAlert(item.Tick, obj)
+				err = p.OnEnemyItemAlert(item.Tick, obj)
 			}
 		case *dota.CDOTAUserMsg_AbilitySteal:
 			// (player_id:0 ability_id:412 ability_level:4 )
 			if p.OnAbilitySteal != nil {
-				p.OnAbilitySteal(item.Tick, obj)
+				err = p.OnAbilitySteal(item.Tick, obj)
 			}
 		case *dota.CDemoSaveGame:
 			// this is not VDF... some new fun stuff instead.
 		case *dota.CDOTAUserMsg_SpectatorPlayerUnitOrders:
 			// (entindex:3 order_type:8 units:403 ability_index:464 queue:false )
 			if p.OnSpectatorPlayerUnitOrders != nil {
-				p.OnSpectatorPlayerUnitOrders(item.Tick, obj)
+				err = p.OnSpectatorPlayerUnitOrders(item.Tick, obj)
 			}
 		case *dota.CDOTAUserMsg_PredictionResult:
 			// (account_id:47276380 match_id:1232716559 correct:true predictions:<item_def:11133 num_correct:1 num_fails:0 > )
 			// item_def is the id from the items_game.txt in vpk
 			if p.OnPredictionResult != nil {
-				p.OnPredictionResult(item.Tick, obj)
+				err = p.OnPredictionResult(item.Tick, obj)
 			}
 		case *dota.CDOTAUserMsg_HPManaAlert:
 			// (player_id:10 target_entindex:54)
 			if p.OnHPManaAlert != nil {
-				p.OnHPManaAlert(item.Tick, obj)
+				err = p.OnHPManaAlert(item.Tick, obj)
 			}
 		case *dota.CDOTAUserMsg_BotChat:
 			// (player_id:4294967295 format:"DOTA_Chat_Spec" message:"dota_chatwheel_message_GoodJob" target:"" )
 			if p.OnBotChat != nil {
-				p.OnBotChat(item.Tick, obj)
+				err = p.OnBotChat(item.Tick, obj)
 			}
-		default:
-			spew.Dump(obj)
 		}
+		if err == nil {
+			err = p.dispatch(item.Tick, item.Object)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	if p.seeking {
+		return nil
 	}
 
 	if p.OnActiveModifierDelta != nil {
 		if len(p.Stsh.ActiveModifierDelta) > 0 {
 			sort.Sort(p.Stsh.ActiveModifierDelta)
-			p.OnActiveModifierDelta(p.Stsh.GetTableNow("ModifierNames").Items, p.Stsh.ActiveModifierDelta)
+			err := p.OnActiveModifierDelta(p.Stsh.GetTableNow("ModifierNames").Items, p.Stsh.ActiveModifierDelta)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	if p.timeline != nil && !p.seeking {
+		p.timeline.sample(p, tick)
+	}
+	if p.inventory != nil && !p.seeking {
+		if err := p.inventory.update(p, tick); err != nil {
+			return err
+		}
+	}
+	if p.abilities != nil && !p.seeking {
+		if err := p.abilities.update(p, tick); err != nil {
+			return err
+		}
+	}
+	if p.kills != nil && !p.seeking {
+		if err := p.kills.update(p, tick); err != nil {
+			return err
+		}
+	}
+	if p.teamfights != nil && !p.seeking {
+		if err := p.teamfights.update(p, tick); err != nil {
+			return err
+		}
+	}
+	if p.wards != nil && !p.seeking {
+		if err := p.wards.update(p, tick); err != nil {
+			return err
 		}
 	}
 
 	if p.AfterTick != nil {
-		p.AfterTick(tick)
+		return p.AfterTick(tick)
 	}
+
+	return nil
 }
 
-func (p *Parser) onGameEvent(tick int, obj *dota.CSVCMsg_GameEvent) {
+func (p *Parser) onGameEvent(tick int, obj *dota.CSVCMsg_GameEvent) error {
 	desc := p.GameEventMap[obj.GetEventid()]
-	dName := desc.GetName()
-
-	switch dName {
-	case "hltv_versioninfo":
-		// version : <*>type:5 val_byte:1
-	case "hltv_message":
-		// text : <*>type:1 val_string:"Please wait for broadcast to start ..."
-	case "hltv_status":
-		// clients : <*>type:3 val_long:523
-		// slots : <*>type:3 val_long:3840
-		// proxies : <*>type:4 val_short:59
-		// master : <*>type:1 val_string:"146.66.152.49:28027"
-	case "dota_combatlog":
-		if p.OnCombatLog != nil {
-			if log := p.combatLogParser.parse(obj); log != nil {
-				p.OnCombatLog(tick, log)
-			}
-		}
-	case "dota_chase_hero":
-		// target1 : <*>type:4 val_short:1418
-		// target2 : <*>type:4 val_short:0
-		// type : <*>type:5 val_byte:0
-		// priority : <*>type:4 val_short:15
-		// gametime : <*>type:2 val_float:2710.3667
-		// highlight : <*>type:6 val_bool:false
-		// target1playerid : <*>type:5 val_byte:1
-		// target2playerid : <*>type:5 val_byte:32
-		// eventtype : <*>type:4 val_short:1
-	case "dota_tournament_item_event":
-		// event_type : <*>type:4 val_short:0  => witness first blood
-		// event_type : <*>type:4 val_short:1  => witness killing spree
-		// event_type : <*>type:4 val_short:3  => witness hero deny
-	default:
-		dKeys := desc.GetKeys()
-		spew.Println(dName)
-		for n, key := range obj.GetKeys() {
-			spew.Println(dKeys[n].GetName(), ":", key)
+	if desc == nil {
+		logTo(p.Logger, SeverityWarning, tick, "CSVCMsg_GameEvent", "no descriptor for event id %d", obj.GetEventid())
+		return nil
+	}
+
+	if desc.GetName() == "dota_combatlog" && (p.OnCombatLog != nil || p.tracksCombatLog()) {
+		log, err := p.combatLogParser.parse(tick, obj)
+		if err != nil {
+			return err
+		}
+		if log != nil && !p.seeking {
+			p.trackCombatLog(log)
+		}
+		if log != nil && p.OnCombatLog != nil {
+			if err = p.OnCombatLog(tick, log); err != nil {
+				return err
+			}
+		}
+	}
+
+	named := p.gameEventHandlers[desc.GetName()]
+	if p.OnGameEvent == nil && len(named) == 0 {
+		return nil
+	}
+
+	event := p.newGameEvent(tick, desc, obj)
+	if p.OnGameEvent != nil {
+		if err := p.OnGameEvent(tick, event); err != nil {
+			return err
 		}
 	}
+	for _, handler := range named {
+		if err := handler(tick, event); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// tracksCombatLog is true if any tracker needs the combat log.
+func (p *Parser) tracksCombatLog() bool {
+	return p.inventory != nil || p.abilities != nil || p.kills != nil || p.teamfights != nil || p.wards != nil
+}
+
+// trackCombatLog passes combat log entries on to the trackers that use them.
+func (p *Parser) trackCombatLog(log CombatLogEntry) {
+	if p.inventory != nil {
+		p.inventory.add(log)
+	}
+	if ability, ok := log.(*CombatLogAbility); ok && p.abilities != nil {
+		p.abilities.logs = append(p.abilities.logs, ability)
+	}
+	if p.kills != nil {
+		p.kills.add(log)
+	}
+	if p.teamfights != nil {
+		p.teamfights.logs = append(p.teamfights.logs, log)
+	}
+	if p.wards != nil {
+		p.wards.add(log)
+	}
 }
 
-func (p *Parser) onCDemoClassInfo(cdci *dota.CDemoClassInfo) {
+func (p *Parser) onCDemoClassInfo(cdci *dota.CDemoClassInfo) error {
 	for _, class := range cdci.GetClasses() {
 		id, name := int(class.GetClassId()), class.GetTableName()
 		p.ClassInfosIdMapping[name] = id
@@ -471,92 +738,191 @@ func (p *Parser) onCDemoClassInfo(cdci *dota.CDemoClassInfo) {
 		props := p.Sth.LoadSendTable(name)
 		multiples := map[string]int{}
 		for _, prop := range props {
-			key := prop.DtName + "." + prop.VarName
-			multiples[key] += 1
+			multiples[prop.DtName+"."+prop.VarName]++
 		}
 		p.Multiples[id] = multiples
 		p.Mapping[id] = props
+		p.PropNames[id] = propNames(props)
+		p.decoders[id] = newClassDecoder(props)
 
-		if p.OnTablename != nil {
-			p.OnTablename(name)
+		if p.OnTablename != nil && !p.seeking {
+			if err := p.OnTablename(name); err != nil {
+				return err
+			}
 		}
 	}
 
 	p.Stsh.ClassInfosNameMapping = p.ClassInfosNameMapping
 	p.Stsh.Mapping = p.Mapping
 	p.Stsh.Multiples = p.Multiples
+	return nil
+}
+
+// propNames maps the VarName of props to their key in PacketEntity.Values.
+// Names declared by several tables of the class, like m_vecOrigin in both
+// DT_BaseEntity and DT_DOTA_BaseNPC, map to the prop of the most derived one.
+func propNames(props []*SendProp) map[string]string {
+	names := map[string]string{}
+	depths := map[string]int{}
+	for _, prop := range props {
+		depth := prop.baseclasses()
+		if d, ok := depths[prop.VarName]; ok && d < depth {
+			continue
+		}
+		names[prop.VarName] = prop.Name
+		depths[prop.VarName] = depth
+	}
+	return names
 }
 
-func (p *Parser) ParsePacket(tick int, pe *dota.CSVCMsg_PacketEntities) {
-	br := NewBitReader(pe.GetEntityData())
-	currentIndex := -1
+func (p *Parser) onPacketEntities(item *OuterParserBaseItem, obj *dota.CSVCMsg_PacketEntities) error {
+	// a DEM_FullPacket contains all entities again, we only need them to
+	// restore the state after seeking.
+	if item.From == dota.EDemoCommands_DEM_Packet ||
+		(item.From == dota.EDemoCommands_DEM_FullPacket && item.Tick == p.restoreTick) {
+		return locate(p.ParsePacket(item.Tick, obj), item.Tick, item.Offset)
+	}
+	return nil
+}
 
+func (p *Parser) ParsePacket(tick int, pe *dota.CSVCMsg_PacketEntities) error {
 	createPackets := []*PacketEntity{}
 	preservePackets := []*PacketEntity{}
+	leavePackets := []*PacketEntity{}
 	deletePackets := []*PacketEntity{}
 
-	for i := 0; i < int(pe.GetUpdatedEntries()); i++ {
-		currentIndex = br.ReadNextEntityIndex(currentIndex)
-		uType := ReadUpdateType(br)
+	err := decodeSafely(tick, 0, func() error {
+		br := NewBitReader(pe.GetEntityData())
+		currentIndex := -1
+
+		for i := 0; i < int(pe.GetUpdatedEntries()); i++ {
+			currentIndex = br.ReadNextEntityIndex(currentIndex)
+			uType := ReadUpdateType(br)
+
+			switch uType {
+			case Create:
+				createPackets = append(createPackets, p.entityCreate(br, currentIndex, tick))
+			case Preserve:
+				preservePackets = append(preservePackets, p.entityPreserve(br, currentIndex, tick))
+			case Leave:
+				if pe := p.Entities[currentIndex]; pe != nil {
+					leavePackets = append(leavePackets, pe)
+				}
+			case Delete:
+				if pe := p.Entities[currentIndex]; pe != nil {
+					deletePackets = append(deletePackets, pe)
+				}
+			}
+		}
 
-		switch uType {
-		case Create:
-			createPackets = append(createPackets, p.entityCreate(br, currentIndex, tick))
-		case Preserve:
-			preservePackets = append(preservePackets, p.entityPreserve(br, currentIndex, tick))
-		case Delete:
-			deletePackets = append(deletePackets, p.entityDelete(br, currentIndex, tick))
+		// deltas end with the entities deleted after they left.
+		if pe.GetIsDelta() {
+			for br.ReadBoolean() {
+				if pe := p.Entities[int(br.ReadUBits(indexBits))]; pe != nil {
+					deletePackets = append(deletePackets, pe)
+				}
+			}
 		}
+		return nil
+	})
+	if err != nil {
+		return err
 	}
 
 	for _, pe := range createPackets {
+		// the slot was reused without the old entity being deleted first.
+		if old := p.Entities[pe.Index]; old != nil && old.Handle() != pe.Handle() {
+			if err := p.entityDelete(old, tick); err != nil {
+				return err
+			}
+		}
 		p.Entities[pe.Index] = pe
 		p.ByHandle[pe.Handle()] = pe
-		if p.OnEntityCreated != nil {
-			p.OnEntityCreated(pe)
+		p.trackEntity(pe)
+		if pe == p.gameRules {
+			if err := p.updateClock(tick); err != nil {
+				return err
+			}
+		}
+		if p.wards != nil && !p.seeking {
+			if err := p.wards.created(p, tick, pe); err != nil {
+				return err
+			}
+		}
+		if p.OnEntityCreated != nil && !p.seeking {
+			if err := p.OnEntityCreated(pe); err != nil {
+				return err
+			}
 		}
 	}
 
 	for _, pe := range preservePackets {
-		if p.OnEntityPreserved != nil {
-			p.OnEntityPreserved(pe)
+		if pe == p.playerResource {
+			p.updatePlayers()
+		} else if pe == p.gameRules {
+			if err := p.updateClock(tick); err != nil {
+				return err
+			}
+		}
+		if p.OnEntityPreserved != nil && !p.seeking {
+			if err := p.OnEntityPreserved(pe); err != nil {
+				return err
+			}
+		}
+	}
+
+	for _, pe := range leavePackets {
+		pe.Tick = tick
+		pe.Type = Leave
+		pe.Active = false
+		if p.OnEntityLeft != nil && !p.seeking {
+			if err := p.OnEntityLeft(pe); err != nil {
+				return err
+			}
 		}
 	}
 
 	for _, pe := range deletePackets {
-		if p.OnEntityDeleted != nil {
-			p.OnEntityDeleted(pe)
+		if err := p.entityDelete(pe, tick); err != nil {
+			return err
 		}
-		// p.Entities[pe.Index] = nil
-		// delete(p.ByHandle, pe.Handle())
 	}
+
+	return nil
 }
 
 func (p *Parser) entityCreate(br *BitReader, currentIndex, tick int) *PacketEntity {
 	pe := &PacketEntity{
 		Tick:      tick,
 		ClassId:   int(br.ReadUBits(p.ClassIdNumBits)),
-		SerialNum: int(br.ReadUBits(10)),
+		SerialNum: int(br.ReadUBits(serialBits)),
 		Index:     currentIndex,
 		Type:      Create,
-		Values:    map[string]interface{}{},
+		Active:    true,
 	}
 	pe.EntityHandle = pe.Handle()
 	pe.Name = p.ClassInfosNameMapping[pe.ClassId]
+	pe.names = p.PropNames[pe.ClassId]
 
-	indices := br.ReadPropertiesIndex()
-	pMapping := p.Mapping[pe.ClassId]
-	pMultiples := p.Multiples[pe.ClassId]
-	values := br.ReadPropertiesValues(pMapping, pMultiples, indices)
+	decoder := p.decoder(pe.ClassId)
+	pe.Fields = make([]interface{}, len(decoder.props))
+	pe.Values = make(map[string]interface{}, len(decoder.props))
 
 	baseline, foundBaseline := p.Stsh.Baseline[pe.ClassId]
 	if foundBaseline {
 		for key, value := range baseline {
 			pe.Values[key] = value
+			if i, ok := decoder.index[key]; ok {
+				pe.Fields[i] = value
+			}
 		}
 	}
-	for key, value := range values {
-		pe.Values[key] = value
+
+	p.indices = br.readPropertiesIndex(p.indices[:0])
+	for _, i := range p.indices {
+		value := decoder.decode[i](br)
+		pe.Fields[i] = value
+		pe.Values[decoder.keys[i]] = value
 	}
 
 	return pe
@@ -566,19 +932,105 @@ func (p *Parser) entityPreserve(br *BitReader, currentIndex, tick int) *PacketEn
 	pe := p.Entities[currentIndex]
 	pe.Tick = tick
 	pe.Type = Preserve
-	indices := br.ReadPropertiesIndex()
-	classId := p.ClassInfosIdMapping[pe.Name]
-	pe.Delta = br.ReadPropertiesValues(p.Mapping[classId], p.Multiples[classId], indices)
-	pe.OldDelta = map[string]interface{}{}
 
-	for key, value := range pe.Delta {
+	if pe.Delta == nil || !p.BorrowMessages {
+		pe.Delta = map[string]interface{}{}
+		pe.OldDelta = map[string]interface{}{}
+	} else {
+		for key := range pe.Delta {
+			delete(pe.Delta, key)
+		}
+		for key := range pe.OldDelta {
+			delete(pe.OldDelta, key)
+		}
+	}
+
+	decoder := p.decoder(pe.ClassId)
+	p.indices = br.readPropertiesIndex(p.indices[:0])
+	for _, i := range p.indices {
+		value := decoder.decode[i](br)
+		key := decoder.keys[i]
 		pe.OldDelta[key] = pe.Values[key]
+		pe.Delta[key] = value
 		pe.Values[key] = value
+		pe.Fields[i] = value
 	}
 
 	return pe
 }
 
-func (p *Parser) entityDelete(br *BitReader, currentIndex, tick int) *PacketEntity {
-	return p.Entities[currentIndex]
+// trackEntity remembers the entities with the state of the game.
+func (p *Parser) trackEntity(pe *PacketEntity) {
+	switch pe.Name {
+	case "DT_DOTA_PlayerResource":
+		p.playerResource = pe
+		p.updatePlayers()
+	case "DT_DOTAGamerulesProxy":
+		p.gameRules = pe
+	}
+}
+
+// decoder returns the decoder of a class, which panics for unknown classes
+// like ReadPropertiesValues does, to be recovered by decodeSafely.
+func (p *Parser) decoder(classId int) *classDecoder {
+	decoder, ok := p.decoders[classId]
+	if !ok {
+		panic(fmt.Errorf("unknown class %d", classId))
+	}
+	return decoder
+}
+
+func (p *Parser) entityDelete(pe *PacketEntity, tick int) error {
+	if pe.Type == Delete {
+		return nil
+	}
+	pe.Tick = tick
+	pe.Type = Delete
+	pe.Active = false
+	if p.Entities[pe.Index] == pe {
+		p.Entities[pe.Index] = nil
+	}
+	if p.ByHandle[pe.Handle()] == pe {
+		delete(p.ByHandle, pe.Handle())
+	}
+	if p.wards != nil && !p.seeking {
+		p.wards.deleted(pe)
+	}
+	if p.OnEntityDeleted != nil && !p.seeking {
+		return p.OnEntityDeleted(pe)
+	}
+	return nil
+}
+
+// arrayKeys returns the keys of the elements of the array prop name of the
+// class, like "m_hItems", ordered by index.
+func (p *Parser) arrayKeys(classId int, name string) []string {
+	cacheKey := arrayKey{classId, name}
+	if keys, ok := p.arrayKeyCache[cacheKey]; ok {
+		return keys
+	}
+	var keys []string
+	if decoder, ok := p.decoders[classId]; ok {
+		for _, key := range decoder.keys {
+			if strings.Contains(key, "."+name+".") {
+				keys = append(keys, key)
+			}
+		}
+	}
+	sort.Slice(keys, func(i, j int) bool { return arrayIndex(keys[i]) < arrayIndex(keys[j]) })
+	if p.arrayKeyCache == nil {
+		p.arrayKeyCache = map[arrayKey][]string{}
+	}
+	p.arrayKeyCache[cacheKey] = keys
+	return keys
+}
+
+type arrayKey struct {
+	classId int
+	name    string
+}
+
+func arrayIndex(key string) int {
+	n, _ := strconv.Atoi(key[strings.LastIndex(key, ".")+1:])
+	return n
 }
@@ -0,0 +1,64 @@
+package yasha
+
+import (
+	"reflect"
+
+	"github.com/golang/protobuf/proto"
+)
+
+// MessageHandler is called for every message of the type it was registered
+// for with OnMessage.
+type MessageHandler func(tick int, msg proto.Message) error
+
+var (
+	intType     = reflect.TypeOf(0)
+	errorType   = reflect.TypeOf((*error)(nil)).Elem()
+	messageType = reflect.TypeOf((*proto.Message)(nil)).Elem()
+)
+
+// OnMessage registers handler for all messages of the same type as msg, e.g.
+//
+//	p.OnMessage(&dota.CDOTAUserMsg_ChatWheel{}, func(tick int, msg proto.Message) error { ... })
+//
+// Any message the replay contains can be subscribed to, not only those that
+// have an On* field. Handlers of a type are called in the order they were
+// registered, after its On* field.
+func (p *Parser) OnMessage(msg proto.Message, handler MessageHandler) {
+	if p.handlers == nil {
+		p.handlers = map[reflect.Type][]MessageHandler{}
+	}
+	t := reflect.TypeOf(msg)
+	p.handlers[t] = append(p.handlers[t], handler)
+}
+
+// Handle is a typed variant of OnMessage, fn has to be a
+// func(tick int, msg *dota.Xxx) error or func(tick int, msg *dota.Xxx), which
+// is called for every message of that type.
+func (p *Parser) Handle(fn interface{}) error {
+	v := reflect.ValueOf(fn)
+	t := v.Type()
+	if t.Kind() != reflect.Func || t.NumIn() != 2 ||
+		t.In(0) != intType || t.In(1).Kind() != reflect.Ptr || !t.In(1).Implements(messageType) ||
+		t.NumOut() > 1 || (t.NumOut() == 1 && t.Out(0) != errorType) {
+		return ErrBadHandler
+	}
+
+	msg := reflect.Zero(t.In(1)).Interface().(proto.Message)
+	p.OnMessage(msg, func(tick int, msg proto.Message) error {
+		out := v.Call([]reflect.Value{reflect.ValueOf(tick), reflect.ValueOf(msg)})
+		if len(out) == 1 && !out[0].IsNil() {
+			return out[0].Interface().(error)
+		}
+		return nil
+	})
+	return nil
+}
+
+func (p *Parser) dispatch(tick int, msg proto.Message) error {
+	for _, handler := range p.handlers[reflect.TypeOf(msg)] {
+		if err := handler(tick, msg); err != nil {
+			return err
+		}
+	}
+	return nil
+}
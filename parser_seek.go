@@ -0,0 +1,157 @@
+package yasha
+
+import (
+	"bufio"
+	"io"
+
+	"github.com/dotabuff/yasha/dota"
+)
+
+// fullPacket is the position of a DEM_FullPacket frame, which contains a
+// snapshot of all string tables and entities.
+type fullPacket struct {
+	tick   int
+	offset int
+}
+
+// SeekToTick restores the entities and string tables as they are at tick,
+// starting from the closest DEM_FullPacket instead of decoding the whole replay
+// up to there. No callbacks are called while seeking, a following Parse
+// continues after tick.
+//
+// The Clock and a Timeline follow along, seeking back drops the samples after
+// tick. The other trackers only see what Parse sees: they keep what they found
+// so far, and miss or repeat what happens between their last tick and tick.
+//
+// It works for replays read from a []byte, a .dem file or an io.ReadSeeker,
+// other sources return ErrNotSeekable.
+func (p *Parser) SeekToTick(tick int) error {
+	if p.Parser.seeker == nil {
+		return ErrNotSeekable
+	}
+	p.setup()
+
+	p.seeking = true
+	defer func() {
+		p.seeking = false
+		p.restoreTick = -1
+	}()
+
+	full, found, err := p.Parser.fullPacketBefore(tick)
+	if err != nil {
+		return err
+	}
+	if tick < p.tick && p.timeline != nil {
+		p.timeline.rewind(tick)
+	}
+
+	// without a snapshot to go back to, we have to start over.
+	if tick < p.tick && !found {
+		if err = p.Parser.seek(headerLength); err != nil {
+			return err
+		}
+		p.init()
+		p.Clock().rewind(0)
+	}
+
+	// the send tables and class infos are only sent on signon, string tables are
+	// created there as well, so we always need those.
+	for p.tick == 0 {
+		if done, err := p.seekFrame(); done || err != nil {
+			return err
+		}
+	}
+
+	if found && (full.tick > p.tick || tick < p.tick) {
+		if err = p.Parser.seek(full.offset); err != nil {
+			return err
+		}
+		p.Clock().rewind(full.tick)
+		p.Entities = make([]*PacketEntity, 2048)
+		p.ByHandle = map[int]*PacketEntity{}
+		p.playerResource, p.gameRules = nil, nil
+		p.tick = full.tick
+		p.pending = nil
+		p.restoreTick = full.tick
+	}
+
+	for p.tick <= tick {
+		if done, err := p.seekFrame(); done || err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// seekFrame processes the next frame, and the last tick once the replay ends.
+func (p *Parser) seekFrame() (bool, error) {
+	err := p.Parser.analyzeFrame(p.collect)
+	if err != io.EOF {
+		return false, err
+	}
+	items := p.pending
+	p.pending = nil
+	return true, p.processTick(p.tick, items)
+}
+
+// seek moves the reader to offset, which has to be the start of a frame.
+func (p *OuterParser) seek(offset int) error {
+	if p.seeker == nil {
+		return ErrNotSeekable
+	}
+	if _, err := p.seeker.Seek(p.base+int64(offset), io.SeekStart); err != nil {
+		return err
+	}
+	p.reader.Reset(p.seeker)
+	p.position = offset
+	return nil
+}
+
+// indexFrame records the frame if it directly follows the ones indexed so far,
+// end is the offset of the next frame.
+func (p *OuterParser) indexFrame(h frameHeader, end int) {
+	if h.offset != p.scanned {
+		return
+	}
+	if h.command == dota.EDemoCommands_DEM_FullPacket {
+		p.fullPackets = append(p.fullPackets, fullPacket{tick: h.tick, offset: h.offset})
+	}
+	p.scanned, p.scannedTick = end, h.tick
+}
+
+// indexFullPackets reads only the frame headers ahead of the parser, until it
+// finds one past tick, to learn where the DEM_FullPacket frames are.
+func (p *OuterParser) indexFullPackets(tick int) error {
+	if p.scanDone || p.scannedTick > tick {
+		return nil
+	}
+
+	for !p.scanDone && p.scannedTick <= tick {
+		if _, err := p.seeker.Seek(p.base+int64(p.scanned), io.SeekStart); err != nil {
+			return err
+		}
+		scanner := &OuterParser{reader: bufio.NewReaderSize(p.seeker, 16), position: p.scanned}
+		h, err := scanner.readFrameHeader()
+		if err == io.EOF {
+			p.scanDone = true
+		} else if err != nil {
+			return err
+		} else {
+			p.indexFrame(h, scanner.position+h.length)
+		}
+	}
+
+	return p.seek(p.position)
+}
+
+func (p *OuterParser) fullPacketBefore(tick int) (fullPacket, bool, error) {
+	if err := p.indexFullPackets(tick); err != nil {
+		return fullPacket{}, false, err
+	}
+	for i := len(p.fullPackets) - 1; i >= 0; i-- {
+		if p.fullPackets[i].tick <= tick {
+			return p.fullPackets[i], true, nil
+		}
+	}
+	return fullPacket{}, false, nil
+}
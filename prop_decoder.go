@@ -0,0 +1,148 @@
+package yasha
+
+import (
+	"fmt"
+	"math"
+)
+
+// decodeFunc reads the value of one prop.
+type decodeFunc func(br *BitReader) interface{}
+
+// classDecoder decodes the props of one class. It's compiled once from the
+// flattened send table, so decoding doesn't have to look at the flags of each
+// prop again.
+type classDecoder struct {
+	props  []*SendProp
+	keys   []string
+	decode []decodeFunc
+	index  map[string]int
+}
+
+func newClassDecoder(props []*SendProp) *classDecoder {
+	d := &classDecoder{
+		props:  props,
+		keys:   make([]string, len(props)),
+		decode: make([]decodeFunc, len(props)),
+		index:  make(map[string]int, len(props)),
+	}
+	for i, prop := range props {
+		d.keys[i] = prop.Name
+		d.decode[i] = compileProp(prop)
+		d.index[prop.Name] = i
+	}
+	return d
+}
+
+func compileProp(prop *SendProp) decodeFunc {
+	switch prop.Type {
+	case DPT_Int:
+		return compileInt(prop)
+	case DPT_Int64:
+		return func(br *BitReader) interface{} { return br.decodeInt64(prop) }
+	case DPT_Float:
+		float := compileFloat(prop)
+		return func(br *BitReader) interface{} { return float(br) }
+	case DPT_Vector:
+		return compileVector(prop)
+	case DPT_VectorXY:
+		float := compileFloat(prop)
+		return func(br *BitReader) interface{} {
+			return &Vector2{X: float(br), Y: float(br)}
+		}
+	case DPT_String:
+		return func(br *BitReader) interface{} { return br.decodeString() }
+	case DPT_Array:
+		return compileArray(prop)
+	}
+	return func(br *BitReader) interface{} {
+		panic(fmt.Errorf("unknown type %d of %s", prop.Type, prop.Name))
+	}
+}
+
+func compileInt(prop *SendProp) decodeFunc {
+	unsigned := prop.Flags&SPROP_UNSIGNED != 0
+	if prop.Flags&SPROP_ENCODED_AGAINST_TICKCOUNT != 0 {
+		if unsigned {
+			return func(br *BitReader) interface{} { return br.decodeVarInt() }
+		}
+		return func(br *BitReader) interface{} {
+			val := br.decodeVarInt()
+			return (val >> 1) ^ -(val & 1)
+		}
+	}
+
+	bits := prop.NumBits
+	if unsigned {
+		return func(br *BitReader) interface{} { return int(br.read(bits)) }
+	}
+	var sign uint = 1 << uint(bits-1)
+	return func(br *BitReader) interface{} {
+		val := br.read(bits)
+		if val >= sign {
+			val = val - sign - sign
+		}
+		return int(val)
+	}
+}
+
+// compileFloat has the same results as BitReader.ReadFloat.
+func compileFloat(prop *SendProp) func(br *BitReader) float64 {
+	flags, bits := prop.Flags, prop.NumBits
+	switch {
+	case flags&SPROP_COORD != 0:
+		return func(br *BitReader) float64 { return float64(br.ReadBitCoord()) }
+	case flags&(SPROP_COORD_MP|SPROP_COORD_MP_INTEGRAL|SPROP_COORD_MP_LOWPRECISION) != 0:
+		return func(br *BitReader) float64 { panic("wtf") }
+	case flags&SPROP_CELL_COORD != 0:
+		return func(br *BitReader) float64 { return float64(br.ReadBitCellCoord(bits, false, false)) }
+	case flags&SPROP_CELL_COORD_INTEGRAL != 0:
+		return func(br *BitReader) float64 { return float64(br.ReadBitCellCoord(bits, true, false)) }
+	case flags&SPROP_CELL_COORD_LOWPRECISION != 0:
+		return func(br *BitReader) float64 { return float64(br.ReadBitCellCoord(bits, false, true)) }
+	case flags&SPROP_NOSCALE != 0:
+		return func(br *BitReader) float64 { return float64(br.ReadBitFloat()) }
+	case flags&SPROP_NORMAL != 0:
+		return func(br *BitReader) float64 { return float64(br.ReadBitNormal()) }
+	}
+
+	divisor := float64(int(1)<<uint(bits) - 1)
+	low, r := prop.LowValue, prop.HighValue-prop.LowValue
+	return func(br *BitReader) float64 {
+		f := float64(br.ReadUBits(bits)) / divisor
+		return f*r + low
+	}
+}
+
+func compileVector(prop *SendProp) decodeFunc {
+	float := compileFloat(prop)
+	if prop.Flags&SPROP_NORMAL == 0 {
+		return func(br *BitReader) interface{} {
+			return &Vector3{X: float(br), Y: float(br), Z: float(br)}
+		}
+	}
+	return func(br *BitReader) interface{} {
+		v := &Vector3{X: float(br), Y: float(br)}
+		if f := v.X*v.X + v.Y*v.Y; f < 1.0 {
+			v.Z = math.Sqrt(1.0 - f)
+		}
+		if br.ReadBoolean() {
+			v.Z = -v.Z
+		}
+		return v
+	}
+}
+
+func compileArray(prop *SendProp) decodeFunc {
+	element := compileProp(prop.Element)
+	bits := 0
+	for n := prop.NumElements; n > 0; n >>= 1 {
+		bits++
+	}
+	return func(br *BitReader) interface{} {
+		elements := make([]interface{}, br.read(bits))
+		for i := range elements {
+			elements[i] = element(br)
+		}
+		return elements
+	}
+}
@@ -2,6 +2,8 @@ package yasha
 
 import (
 	"sort"
+	"strconv"
+	"strings"
 
 	"github.com/dotabuff/yasha/dota"
 )
@@ -46,6 +48,7 @@ const (
 )
 
 type SendProp struct {
+	Name      string // the key in PacketEntity.Values, see LoadSendTable
 	DtName    string
 	VarName   string
 	Type      DPTType
@@ -54,6 +57,18 @@ type SendProp struct {
 	NumBits   int
 	LowValue  float64
 	HighValue float64
+
+	// DPT_Array props have up to NumElements values, decoded like Element.
+	NumElements int
+	Element     *SendProp
+
+	// the DataTable props leading to this one, used to name it.
+	scope []scopeEntry
+}
+
+type scopeEntry struct {
+	table   string
+	varName string
 }
 
 type Helper struct {
@@ -74,12 +89,20 @@ func (sth *Helper) SetSendTable(name string, table *dota.CSVCMsg_SendTable) {
 	sth.sendTables[name] = table
 }
 
+// LoadSendTable flattens the send table of a class into the props of its
+// entities, in the order they are sent.
+//
+// Props are named after the closest DT_ table they are in, followed by the
+// names of the nested DataTable props and their own name, e.g.
+// "DT_DOTA_PlayerResource.m_iKills.005". Where that's ambiguous, the name is
+// extended with the DataTable props leading to that table, up to the root.
 func (sth *Helper) LoadSendTable(sendTableName string) []*SendProp {
 	sth.flatSendTable = []*SendProp{}
 	sth.excludedSendProp = []*SendProp{}
 	sth.excludedSendProp = sth.getPropsExcluded(sendTableName)
-	sth.buildHierarchy(sendTableName)
+	sth.buildHierarchy(sendTableName, []scopeEntry{{table: sendTableName}})
 	sth.sortByPriority()
+	nameProps(sth.flatSendTable)
 	return sth.flatSendTable
 }
 
@@ -109,43 +132,62 @@ func (sth *Helper) getPropsExcluded(sendTableName string) []*SendProp {
 	return result
 }
 
-func (sth *Helper) buildHierarchy(sendTableName string) {
+func (sth *Helper) buildHierarchy(sendTableName string, scope []scopeEntry) {
 	result := []*SendProp{}
-	sth.buildHierarchyIterateProps(sendTableName, &result)
+	sth.buildHierarchyIterateProps(sendTableName, scope, &result)
 	sth.flatSendTable = append(sth.flatSendTable, result...)
 }
 
-func (sth *Helper) buildHierarchyIterateProps(sendTableName string, result *[]*SendProp) {
+func (sth *Helper) buildHierarchyIterateProps(sendTableName string, scope []scopeEntry, result *[]*SendProp) {
 	pTable := sth.sendTables[sendTableName]
-	for _, pProp := range pTable.GetProps() {
+	pProps := pTable.GetProps()
+	for i, pProp := range pProps {
 		pFlags := pProp.GetFlags()
 		pType := pProp.GetType()
+		// props inside arrays are only the template for the elements of the
+		// DPT_Array following them.
 		if pFlags&int32(SPROP_EXCLUDE) != 0 ||
-			pType == int32(DPT_Array) ||
+			pFlags&int32(SPROP_INSIDEARRAY) != 0 ||
 			sth.hasExcludedSendProp(sendTableName, pProp.GetVarName()) {
 			continue
 		}
 		if pType == int32(DPT_DataTable) {
+			inner := make([]scopeEntry, len(scope), len(scope)+1)
+			copy(inner, scope)
+			inner = append(inner, scopeEntry{table: pProp.GetDtName(), varName: pProp.GetVarName()})
 			if pFlags&int32(SPROP_COLLAPSIBLE) != 0 {
-				sth.buildHierarchyIterateProps(pProp.GetDtName(), result)
+				sth.buildHierarchyIterateProps(pProp.GetDtName(), inner, result)
 			} else {
-				sth.buildHierarchy(pProp.GetDtName())
+				sth.buildHierarchy(pProp.GetDtName(), inner)
 			}
 		} else {
-			*result = append(*result, &SendProp{
-				DtName:    sendTableName,
-				Flags:     Flag(pProp.GetFlags()),
-				NumBits:   int(pProp.GetNumBits()),
-				Priority:  int(pProp.GetPriority()),
-				Type:      DPTType(pProp.GetType()),
-				VarName:   pProp.GetVarName(),
-				LowValue:  float64(pProp.GetLowValue()),
-				HighValue: float64(pProp.GetHighValue()),
-			})
+			prop := newSendProp(sendTableName, pProp, scope)
+			if pType == int32(DPT_Array) {
+				if i == 0 || pProps[i-1].GetFlags()&int32(SPROP_INSIDEARRAY) == 0 {
+					continue
+				}
+				prop.NumElements = int(pProp.GetNumElements())
+				prop.Element = newSendProp(sendTableName, pProps[i-1], scope)
+			}
+			*result = append(*result, prop)
 		}
 	}
 }
 
+func newSendProp(sendTableName string, pProp *dota.CSVCMsg_SendTableSendpropT, scope []scopeEntry) *SendProp {
+	return &SendProp{
+		DtName:    sendTableName,
+		Flags:     Flag(pProp.GetFlags()),
+		NumBits:   int(pProp.GetNumBits()),
+		Priority:  int(pProp.GetPriority()),
+		Type:      DPTType(pProp.GetType()),
+		VarName:   pProp.GetVarName(),
+		LowValue:  float64(pProp.GetLowValue()),
+		HighValue: float64(pProp.GetHighValue()),
+		scope:     scope,
+	}
+}
+
 func (sth *Helper) hasExcludedSendProp(sendTableName string, pVarName string) bool {
 	for _, p := range sth.excludedSendProp {
 		if p.DtName == sendTableName && p.VarName == pVarName {
@@ -199,3 +241,79 @@ func (sth *Helper) sortByPriority() {
 		}
 	}
 }
+
+// nameProps sets the Name of all props, going further up their scope for
+// those that would have the same name otherwise. Names that are still the
+// same at the root get the index of the prop, like "DT_Root.m_b-4".
+func nameProps(props []*SendProp) {
+	depth := make([]int, len(props))
+	for {
+		byName := map[string][]int{}
+		for i, prop := range props {
+			prop.Name = prop.name(depth[i])
+			byName[prop.Name] = append(byName[prop.Name], i)
+		}
+
+		extended := false
+		for _, indices := range byName {
+			if len(indices) < 2 {
+				continue
+			}
+			for _, i := range indices {
+				if props[i].start(depth[i]) > 0 {
+					depth[i]++
+					extended = true
+				}
+			}
+		}
+		if extended {
+			continue
+		}
+		for _, indices := range byName {
+			if len(indices) > 1 {
+				for _, i := range indices {
+					props[i].Name += "-" + strconv.Itoa(i)
+				}
+			}
+		}
+		return
+	}
+}
+
+// baseclasses counts the base classes between the class and the table that
+// declares the prop.
+func (prop *SendProp) baseclasses() int {
+	n := 0
+	for _, entry := range prop.scope {
+		if entry.varName == "baseclass" {
+			n++
+		}
+	}
+	return n
+}
+
+// start returns the index in the scope the name starts at, skipping depth DT_
+// tables from the innermost one outwards.
+func (prop *SendProp) start(depth int) int {
+	for i := len(prop.scope) - 1; i > 0; i-- {
+		if strings.HasPrefix(prop.scope[i].table, "DT_") {
+			if depth == 0 {
+				return i
+			}
+			depth--
+		}
+	}
+	return 0
+}
+
+func (prop *SendProp) name(depth int) string {
+	if len(prop.scope) == 0 {
+		return prop.DtName + "." + prop.VarName
+	}
+	start := prop.start(depth)
+	parts := []string{prop.scope[start].table}
+	for _, entry := range prop.scope[start+1:] {
+		parts = append(parts, entry.varName)
+	}
+	return strings.Join(append(parts, prop.VarName), ".")
+}
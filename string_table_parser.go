@@ -1,10 +1,6 @@
 package yasha
 
-import (
-	"math"
-
-	"github.com/davecgh/go-spew/spew"
-)
+import "math"
 
 const (
 	MaxNameLength  = 0x400
@@ -74,7 +70,6 @@ func Parse(data []byte, numEntries, maxEntries, dataSizeBits int, dataFixedSize
 				} else {
 					s := keyHistory[basis]
 					if int(length) > len(s) {
-						spew.Dump(s, length)
 						nameBuf += s + br.ReadStringN(int(MaxNameLength))
 					} else {
 						nameBuf += s[0:length] + br.ReadStringN(int(MaxNameLength-length))
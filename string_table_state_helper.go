@@ -71,34 +71,70 @@ func writeStringTables(directory string, tick int, t string) {
 	}
 }
 
-func (helper *StateHelper) AppendPacket(packet *OuterParserBaseItem) {
+func (helper *StateHelper) AppendPacket(packet *OuterParserBaseItem) error {
+	var err error
 	switch obj := packet.Object.(type) {
 	case *dota.CDemoStringTables:
 		// looks like we don't need them, they are just like CST
 		// helper.OnCDST(packet.Tick, obj)
 	case *dota.CSVCMsg_CreateStringTable:
 		helper.packets = append(helper.packets, packet)
-		helper.OnCST(packet.Tick, obj)
+		err = helper.OnCST(packet.Tick, obj)
 	case *dota.CSVCMsg_UpdateStringTable:
 		helper.packets = append(helper.packets, packet)
-		helper.OnUST(packet.Tick, obj)
+		err = helper.OnUST(packet.Tick, obj)
 	default:
-		panic("Cannot handle this type")
+		err = fmt.Errorf("cannot handle %T", obj)
 	}
+	return locate(err, packet.Tick, packet.Offset)
 }
 
-func (helper *StateHelper) OnCDST(tick int, obj *dota.CDemoStringTables) {
-	/*
-		for _, table := range obj.GetTables() {
-			writeStringTables("CDemoStringTables/"+table.GetTableName(), tick, spew.Sdump(table))
+// OnCDST replaces the string tables with the snapshot of a DEM_FullPacket,
+// which is how we restore the state after seeking.
+func (helper *StateHelper) OnCDST(tick int, obj *dota.CDemoStringTables) error {
+	for _, t := range obj.GetTables() {
+		current := helper.GetTableNow(t.GetTableName())
+		if current == nil {
+			return &ParseError{Err: ErrUnknownTable, Tick: tick, Cause: Error(t.GetTableName())}
 		}
-	*/
+
+		items := map[int]*StringTableItem{}
+		for index, item := range t.GetItems() {
+			items[index] = &StringTableItem{Str: item.GetStr(), Data: item.GetData()}
+		}
+
+		var err error
+		switch current.Name {
+		case "ActiveModifiers":
+			err = helper.parseActiveModifiers(items)
+		case "userinfo":
+			err = parseUserinfo(items)
+		case "instancebaseline":
+			err = helper.updateInstanceBaseline(items)
+		}
+		if err != nil {
+			return err
+		}
+
+		current.Items = items
+		stCopy := &StringTable{
+			Index: current.Index,
+			Items: map[int]*StringTableItem{},
+			Name:  current.Name,
+			Tick:  tick,
+		}
+		for key, value := range items {
+			stCopy.Items[key] = value
+		}
+		helper.evolution[current.Index] = append(helper.evolution[current.Index], stCopy)
+	}
+	return nil
 }
 
-func (helper *StateHelper) OnCST(tick int, obj *dota.CSVCMsg_CreateStringTable) {
+func (helper *StateHelper) OnCST(tick int, obj *dota.CSVCMsg_CreateStringTable) error {
 	if tick != 0 {
 		// tested against a ton of replays, hasn't happened yet...
-		panic("creating string table after first tick?")
+		return &ParseError{Err: ErrStringTable, Tick: tick, Cause: Error("creating string table after first tick")}
 	}
 
 	helper.metaTables[helper.lastCreateIndex] = &CacheItem{
@@ -115,16 +151,20 @@ func (helper *StateHelper) OnCST(tick int, obj *dota.CSVCMsg_CreateStringTable)
 		Items: ParseCST(obj),
 	}
 
+	var err error
 	switch table.Name {
 	case "ActiveModifiers":
-		helper.parseActiveModifiers(table.Items)
+		err = helper.parseActiveModifiers(table.Items)
 	case "instancebaseline":
-		helper.updateInstanceBaseline(table.Items)
+		err = helper.updateInstanceBaseline(table.Items)
 	case "userinfo":
-		parseUserinfo(table.Items)
+		err = parseUserinfo(table.Items)
 	default:
 		// panic("Cannot parse table: " + table.Name)
 	}
+	if err != nil {
+		return err
+	}
 
 	// writeStringTables("CreateStringTable/"+table.Name, tick, spew.Sdump(table))
 
@@ -133,29 +173,36 @@ func (helper *StateHelper) OnCST(tick int, obj *dota.CSVCMsg_CreateStringTable)
 	helper.evolution[helper.lastCreateIndex] = append(helper.evolution[helper.lastCreateIndex], &table)
 
 	helper.lastCreateIndex++
+	return nil
 }
 
 // NOTE:
 // We ignore the "userinfo" table decoding process since it's a PITA and has no useful info anyway.
 // In case we ever need it, a struct describing the binary is at:
 // https://github.com/mitsuhiko/dota2-demoinfo2/blob/4ca45a87c631787eab140d313a3f21210b543741/demofile.h#L48
-func (helper *StateHelper) OnUST(tick int, obj *dota.CSVCMsg_UpdateStringTable) {
+func (helper *StateHelper) OnUST(tick int, obj *dota.CSVCMsg_UpdateStringTable) error {
 	tableId := int(obj.GetTableId())
 
-	meta := helper.metaTables[tableId]
+	meta, current := helper.metaTables[tableId], helper.current[tableId]
+	if meta == nil || current == nil {
+		return &ParseError{Err: ErrUnknownTable, Tick: tick, Cause: fmt.Errorf("table id %d", tableId)}
+	}
 	update := ParseUST(obj, meta)
 
-	current := helper.current[tableId]
+	var err error
 	switch current.Name {
 	case "ActiveModifiers":
-		helper.parseActiveModifiers(update)
+		err = helper.parseActiveModifiers(update)
 	case "userinfo":
-		parseUserinfo(update)
+		err = parseUserinfo(update)
 	case "instancebaseline":
-		helper.updateInstanceBaseline(update)
+		err = helper.updateInstanceBaseline(update)
 	default:
 		// panic("Cannot parse table: " + meta.Name)
 	}
+	if err != nil {
+		return err
+	}
 	// writeStringTables("UpdateStringTable/"+current.Name, tick, spew.Sdump(update))
 
 	for key, value := range update {
@@ -174,33 +221,38 @@ func (helper *StateHelper) OnUST(tick int, obj *dota.CSVCMsg_UpdateStringTable)
 	}
 
 	helper.evolution[tableId] = append(helper.evolution[tableId], stCopy)
+	return nil
 }
 
-func (helper *StateHelper) updateInstanceBaseline(update map[int]*StringTableItem) {
+func (helper *StateHelper) updateInstanceBaseline(update map[int]*StringTableItem) error {
 	for _, item := range helper.pendingBaseline {
-		helper.updateInstanceBaselineItem(item)
+		if err := helper.updateInstanceBaselineItem(item); err != nil {
+			return err
+		}
 	}
 	for _, item := range update {
-		helper.updateInstanceBaselineItem(item)
+		if err := helper.updateInstanceBaselineItem(item); err != nil {
+			return err
+		}
 	}
+	return nil
 }
 
-func (helper *StateHelper) updateInstanceBaselineItem(item *StringTableItem) {
+func (helper *StateHelper) updateInstanceBaselineItem(item *StringTableItem) error {
 	classId, err := strconv.Atoi(item.Str)
 	if err != nil {
-		panic(err)
+		return &ParseError{Err: ErrStringTable, Cause: err}
 	}
 
 	className := helper.ClassInfosNameMapping[classId]
 	if className == "DT_DOTAPlayer" {
-		return
+		return nil
 	}
 
 	mapping := helper.Mapping[classId]
-	multiples := helper.Multiples[classId]
-	if len(mapping) == 0 || len(multiples) == 0 {
+	if len(mapping) == 0 {
 		helper.pendingBaseline = append(helper.pendingBaseline, item)
-		return
+		return nil
 	}
 
 	baseline, found := helper.Baseline[classId]
@@ -212,28 +264,30 @@ func (helper *StateHelper) updateInstanceBaselineItem(item *StringTableItem) {
 	if len(item.Data) > 0 {
 		br := NewBitReader(item.Data)
 		indices := br.ReadPropertiesIndex()
-		baseValues := br.ReadPropertiesValues(mapping, multiples, indices)
+		baseValues := br.ReadPropertiesValues(mapping, indices)
 		for key, value := range baseValues {
 			baseline[key] = value
 		}
 	}
 
 	helper.Baseline[classId] = baseline
+	return nil
 }
 
-func (helper *StateHelper) parseActiveModifiers(entries map[int]*StringTableItem) {
+func (helper *StateHelper) parseActiveModifiers(entries map[int]*StringTableItem) error {
 	for _, e := range entries {
 		if len(e.Data) > 0 {
 			o := &dota.CDOTAModifierBuffTableEntry{}
 			err := proto.Unmarshal(e.Data, o)
 			if err != nil {
-				panic(err)
+				return &ParseError{Err: ErrProtoDecode, Cause: err}
 			}
 			e.Data = e.Data[:0]
 			e.ModifierBuff = o
 			helper.ActiveModifierDelta = append(helper.ActiveModifierDelta, o)
 		}
 	}
+	return nil
 }
 
 func (helper *StateHelper) GetStateAtTick(tick int) map[int]*StringTable {
@@ -276,7 +330,7 @@ func (helper *StateHelper) GetTableNow(tableName string) (result *StringTable) {
 	return
 }
 
-func parseUserinfo(entries map[int]*StringTableItem) {
+func parseUserinfo(entries map[int]*StringTableItem) error {
 	for _, e := range entries {
 		if len(e.Data) == 0 {
 			continue
@@ -284,7 +338,9 @@ func parseUserinfo(entries map[int]*StringTableItem) {
 
 		raw := &rawUserinfo{}
 		buf := bytes.NewBuffer(e.Data)
-		err := binary.Read(buf, binary.LittleEndian, raw)
+		if err := binary.Read(buf, binary.LittleEndian, raw); err != nil {
+			return &ParseError{Err: ErrStringTable, Cause: err}
+		}
 
 		info := &Userinfo{}
 		info.XUID = raw.Xuid
@@ -319,13 +375,10 @@ func parseUserinfo(entries map[int]*StringTableItem) {
 		info.GUID = string(guid)
 		info.SteamID = guidToCommunityID(info.GUID)
 
-		if err != nil {
-			panic(err)
-		}
-
 		e.Userinfo = info
 		e.Data = e.Data[:0]
 	}
+	return nil
 }
 
 var (
@@ -0,0 +1,243 @@
+package yasha
+
+import (
+	"math"
+	"sort"
+	"time"
+)
+
+// TeamfightRules decide what's a fight and who took part.
+type TeamfightRules struct {
+	// Cooldown is how long without damage between heroes of different teams
+	// or hero deaths it takes for a fight to end.
+	Cooldown time.Duration
+	// MinDeaths drops fights with fewer hero deaths.
+	MinDeaths int
+	// Radius makes heroes this close to a death take part in the fight, even
+	// if they didn't deal or take damage. 0 turns it off.
+	Radius float64
+}
+
+// DefaultTeamfightRules are what's usually called a teamfight.
+var DefaultTeamfightRules = TeamfightRules{
+	Cooldown:  15 * time.Second,
+	MinDeaths: 3,
+	Radius:    1500,
+}
+
+// Teamfight is a period of heroes of different teams fighting, see
+// Parser.TrackTeamfights. StartTick and EndTick are the first and last tick
+// with damage between heroes or a hero death.
+type Teamfight struct {
+	StartTick int
+	EndTick   int
+	Start     time.Duration // game time, only valid if the game clock was Started
+	End       time.Duration
+	Deaths    []TeamfightDeath
+	Players   []*TeamfightPlayer // ordered by Slot
+	GoldSwing int                // gold radiant heroes gained minus what dire heroes gained
+	XPSwing   int
+}
+
+type TeamfightDeath struct {
+	Tick     int
+	Victim   string
+	Killer   string
+	Position Vector3 // of the victim, if its hero entity is known
+}
+
+// TeamfightPlayer is what a hero did during a fight, Abilities and Items count
+// the uses by name.
+type TeamfightPlayer struct {
+	Slot        int // -1 if the hero isn't one of a player
+	Team        int
+	Hero        string
+	DamageDealt int
+	DamageTaken int
+	Deaths      int
+	Gold        int
+	XP          int
+	Abilities   map[string]int
+	Items       map[string]int
+
+	involved bool
+}
+
+// Teamfights finds fights in the combat log as the parser goes along.
+type Teamfights struct {
+	Rules  TeamfightRules
+	Fights []*Teamfight
+
+	current  *Teamfight
+	players  map[string]*TeamfightPlayer
+	lastTick int
+	logs     []CombatLogEntry
+}
+
+// TrackTeamfights makes Parse look for fights, calling OnTeamfight with every
+// one as it ends. The returned Teamfights keeps all of them.
+func (p *Parser) TrackTeamfights(rules TeamfightRules) *Teamfights {
+	p.teamfights = &Teamfights{Rules: rules}
+	return p.teamfights
+}
+
+func (t *Teamfights) update(p *Parser, tick int) error {
+	defer func() {
+		t.logs = t.logs[:0]
+	}()
+
+	for _, log := range t.logs {
+		if t.fighting(p, log) {
+			if t.current == nil {
+				t.current = &Teamfight{StartTick: tick}
+				t.current.Start, _ = p.Clock().TickToGameTime(tick)
+				t.players = map[string]*TeamfightPlayer{}
+			}
+			t.current.EndTick = tick
+			t.current.End, _ = p.Clock().TickToGameTime(tick)
+			t.lastTick = tick
+			break
+		}
+	}
+	if t.current != nil {
+		for _, log := range t.logs {
+			t.record(p, tick, log)
+		}
+	}
+
+	if t.current != nil && t.since(p, tick) >= t.Rules.Cooldown {
+		return t.close(p)
+	}
+	return nil
+}
+
+// since returns the game time from lastTick to tick, pauses don't count.
+func (t *Teamfights) since(p *Parser, tick int) time.Duration {
+	now, ok := p.Clock().TickToGameTime(tick)
+	last, _ := p.Clock().TickToGameTime(t.lastTick)
+	if !ok {
+		return p.Clock().duration(float64(tick - t.lastTick))
+	}
+	return now - last
+}
+
+// fighting is true for damage between heroes of different teams and hero
+// deaths.
+func (t *Teamfights) fighting(p *Parser, log CombatLogEntry) bool {
+	switch log := log.(type) {
+	case *CombatLogDamage:
+		return log.AttackerIsHero && log.TargetIsHero && !log.TargetIsIllusion && p.enemies(log.Attacker, log.Target)
+	case *CombatLogDeath:
+		return log.TargetIsHero && !log.TargetIsIllusion
+	}
+	return false
+}
+
+func (t *Teamfights) record(p *Parser, tick int, log CombatLogEntry) {
+	switch log := log.(type) {
+	case *CombatLogDamage:
+		if t.fighting(p, log) {
+			attacker, target := t.player(p, log.Attacker), t.player(p, log.Target)
+			attacker.DamageDealt += log.Value
+			attacker.involved = true
+			target.DamageTaken += log.Value
+			target.involved = true
+		}
+	case *CombatLogDeath:
+		if !t.fighting(p, log) {
+			return
+		}
+		death := TeamfightDeath{Tick: tick, Victim: log.Target, Killer: log.Attacker}
+		victim := t.player(p, log.Target)
+		victim.Deaths++
+		victim.involved = true
+		if hero := p.heroByName(log.Target); hero != nil {
+			death.Position, _ = hero.Position()
+			t.nearby(p, death.Position)
+		}
+		t.current.Deaths = append(t.current.Deaths, death)
+	case *CombatLogAbility:
+		if log.AttackerIsHero && !log.AttackerIsIllusion {
+			t.player(p, log.Attacker).Abilities[log.Ability]++
+		}
+	case *CombatLogItem:
+		if log.UserIsHero && !log.AttackerIsIllusion {
+			t.player(p, log.User).Items[log.Item]++
+		}
+	case *CombatLogGold:
+		t.player(p, log.Target).Gold += log.Value
+	case *CombatLogXP:
+		t.player(p, log.Target).XP += log.Value
+	}
+}
+
+// nearby makes the heroes of all players within Radius of pos take part.
+func (t *Teamfights) nearby(p *Parser, pos Vector3) {
+	if t.Rules.Radius <= 0 {
+		return
+	}
+	for _, player := range p.Match().Players {
+		hero := p.hero(player.Slot)
+		if hero == nil {
+			continue
+		}
+		if heroPos, ok := hero.Position(); ok && math.Hypot(heroPos.X-pos.X, heroPos.Y-pos.Y) <= t.Rules.Radius {
+			name, _ := hero.GetString("m_iszUnitName")
+			t.player(p, name).involved = true
+		}
+	}
+}
+
+// player returns the stats of a hero in the current fight, adding them if
+// it's new.
+func (t *Teamfights) player(p *Parser, hero string) *TeamfightPlayer {
+	if player, ok := t.players[hero]; ok {
+		return player
+	}
+	player := &TeamfightPlayer{Slot: -1, Hero: hero, Abilities: map[string]int{}, Items: map[string]int{}}
+	for _, ps := range p.Match().Players {
+		if ps.Hero == hero {
+			player.Slot = ps.Slot
+			player.Team = ps.Team
+			break
+		}
+	}
+	t.players[hero] = player
+	return player
+}
+
+// close ends the current fight, keeping it if it had enough deaths.
+func (t *Teamfights) close(p *Parser) error {
+	fight := t.current
+	t.current = nil
+	if fight == nil || len(fight.Deaths) < t.Rules.MinDeaths {
+		return nil
+	}
+
+	for _, player := range t.players {
+		switch player.Team {
+		case TeamRadiant:
+			fight.GoldSwing += player.Gold
+			fight.XPSwing += player.XP
+		case TeamDire:
+			fight.GoldSwing -= player.Gold
+			fight.XPSwing -= player.XP
+		}
+		if player.involved {
+			fight.Players = append(fight.Players, player)
+		}
+	}
+	sort.Slice(fight.Players, func(i, j int) bool {
+		a, b := fight.Players[i], fight.Players[j]
+		if a.Slot != b.Slot {
+			return a.Slot < b.Slot
+		}
+		return a.Hero < b.Hero
+	})
+
+	t.Fights = append(t.Fights, fight)
+	if p.OnTeamfight != nil {
+		return p.OnTeamfight(fight)
+	}
+	return nil
+}
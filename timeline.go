@@ -0,0 +1,132 @@
+package yasha
+
+import (
+	"sort"
+	"time"
+)
+
+// Timeline holds the stats of every player, sampled at a fixed interval of game
+// time, see Parser.SampleTimeline. It's stored by column: Ticks[i], Times[i]
+// and element i of every series of the players belong to the same sample.
+type Timeline struct {
+	Interval time.Duration
+	Ticks    []int
+	Times    []time.Duration // since the game started
+	Players  []*PlayerTimeline
+
+	next time.Duration
+}
+
+// PlayerTimeline holds the series of one player, players that show up late are
+// 0 until then, as are their X and Y while the hero isn't known. NetWorth is
+// only there for replays with m_iNetWorth.
+type PlayerTimeline struct {
+	Slot     int
+	Gold     []int32
+	XP       []int32
+	Level    []int32
+	LastHits []int32
+	Denies   []int32
+	Kills    []int32
+	Deaths   []int32
+	Assists  []int32
+	NetWorth []int32
+	X, Y     []float32
+}
+
+// SampleTimeline makes Parse sample the player resource and heroes every
+// interval of game time, from the start of the game on. The returned Timeline
+// grows as the parser goes along.
+func (p *Parser) SampleTimeline(interval time.Duration) *Timeline {
+	p.timeline = &Timeline{Interval: interval}
+	return p.timeline
+}
+
+func (t *Timeline) sample(p *Parser, tick int) {
+	now, ok := p.Clock().TickToGameTime(tick)
+	if !ok || now < t.next {
+		return
+	}
+	t.advance(now)
+
+	t.Ticks = append(t.Ticks, tick)
+	t.Times = append(t.Times, now)
+	for _, player := range p.Match().Players {
+		slot := player.Slot
+		stat := func(name string) int32 {
+			value, _ := toInt(p.resourceValue(name, slot))
+			return int32(value)
+		}
+		var pos Vector3
+		if hero := p.hero(slot); hero != nil {
+			pos, _ = hero.Position()
+		}
+
+		pt := t.player(slot)
+		pt.Gold = append(pt.Gold, stat("m_iReliableGold")+stat("m_iUnreliableGold"))
+		pt.XP = append(pt.XP, stat("m_iTotalEarnedXP"))
+		pt.Level = append(pt.Level, stat("m_iLevel"))
+		pt.LastHits = append(pt.LastHits, stat("m_iLastHitCount"))
+		pt.Denies = append(pt.Denies, stat("m_iDenyCount"))
+		pt.Kills = append(pt.Kills, stat("m_iKills"))
+		pt.Deaths = append(pt.Deaths, stat("m_iDeaths"))
+		pt.Assists = append(pt.Assists, stat("m_iAssists"))
+		pt.NetWorth = append(pt.NetWorth, stat("m_iNetWorth"))
+		pt.X = append(pt.X, float32(pos.X))
+		pt.Y = append(pt.Y, float32(pos.Y))
+	}
+}
+
+// advance sets when the sample after the one at now is due.
+func (t *Timeline) advance(now time.Duration) {
+	t.next = now
+	if t.Interval > 0 {
+		t.next = (now/t.Interval + 1) * t.Interval
+	}
+}
+
+// rewind drops the samples after tick, for SeekToTick.
+func (t *Timeline) rewind(tick int) {
+	n := sort.SearchInts(t.Ticks, tick+1)
+	if n == len(t.Ticks) {
+		return
+	}
+	t.Ticks, t.Times = t.Ticks[:n], t.Times[:n]
+	for _, pt := range t.Players {
+		for _, series := range []*[]int32{&pt.Gold, &pt.XP, &pt.Level, &pt.LastHits, &pt.Denies, &pt.Kills, &pt.Deaths, &pt.Assists, &pt.NetWorth} {
+			*series = (*series)[:n]
+		}
+		pt.X, pt.Y = pt.X[:n], pt.Y[:n]
+	}
+	t.next = 0
+	if n > 0 {
+		t.advance(t.Times[n-1])
+	}
+}
+
+// player returns the series of slot, adding them with zeros for the samples
+// before the current one if it's new.
+func (t *Timeline) player(slot int) *PlayerTimeline {
+	for _, pt := range t.Players {
+		if pt.Slot == slot {
+			return pt
+		}
+	}
+	n := len(t.Ticks) - 1
+	pt := &PlayerTimeline{
+		Slot:     slot,
+		Gold:     make([]int32, n),
+		XP:       make([]int32, n),
+		Level:    make([]int32, n),
+		LastHits: make([]int32, n),
+		Denies:   make([]int32, n),
+		Kills:    make([]int32, n),
+		Deaths:   make([]int32, n),
+		Assists:  make([]int32, n),
+		NetWorth: make([]int32, n),
+		X:        make([]float32, n),
+		Y:        make([]float32, n),
+	}
+	t.Players = append(t.Players, pt)
+	return pt
+}
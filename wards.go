@@ -0,0 +1,231 @@
+package yasha
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// wardDeathWindow is how long before a ward entity is deleted its death in
+// the combat log may be.
+const wardDeathWindow = 2 * time.Second
+
+type WardType int
+
+const (
+	WardObserver WardType = iota
+	WardSentry
+)
+
+func (t WardType) String() string {
+	switch t {
+	case WardObserver:
+		return "observer"
+	case WardSentry:
+		return "sentry"
+	}
+	return fmt.Sprintf("WardType(%d)", int(t))
+}
+
+// unitName is the name of the ward in the combat log.
+func (t WardType) unitName() string {
+	if t == WardSentry {
+		return "npc_dota_sentry_wards"
+	}
+	return "npc_dota_observer_wards"
+}
+
+// Ward is a placed observer or sentry ward, see Parser.TrackWards.
+type Ward struct {
+	Handle      int
+	Type        WardType
+	Team        int
+	Placer      string // unit name of the hero, like "npc_dota_hero_axe"
+	PlacerSlot  int    // -1 if the placer isn't known
+	Position    Vector3
+	PlacedTick  int
+	Placed      time.Duration // game time, only valid if the game clock was Started
+	RemovedTick int           // 0 while the ward is up
+	Removed     time.Duration
+	Dewarder    string // unit name of the enemy who killed it, empty if it expired
+	Denier      string // unit name of the ally who denied it
+}
+
+// Wards follows the ward entities and their deaths in the combat log.
+type Wards struct {
+	Wards []*Ward // in the order they were placed
+
+	active  map[int]*Ward
+	removed []*Ward
+	logs    []*CombatLogDeath
+	deaths  []wardDeath
+}
+
+type wardDeath struct {
+	tick int
+	log  *CombatLogDeath
+}
+
+// TrackWards makes Parse follow all wards, calling OnWardPlaced and
+// OnWardRemoved. A ward that's deleted shortly after it died in the combat log
+// was dewarded by an enemy or denied by an ally, otherwise it expired.
+func (p *Parser) TrackWards() *Wards {
+	p.wards = &Wards{active: map[int]*Ward{}}
+	return p.wards
+}
+
+// Active returns the wards that are up, in the order they were placed.
+func (w *Wards) Active() []*Ward {
+	var active []*Ward
+	for _, ward := range w.Wards {
+		if ward.RemovedTick == 0 {
+			active = append(active, ward)
+		}
+	}
+	return active
+}
+
+func wardType(pe *PacketEntity) (WardType, bool) {
+	switch pe.Name {
+	case "DT_DOTA_NPC_Observer_Ward":
+		return WardObserver, true
+	case "DT_DOTA_NPC_Observer_Ward_TrueSight":
+		return WardSentry, true
+	}
+	return 0, false
+}
+
+func (w *Wards) created(p *Parser, tick int, pe *PacketEntity) error {
+	t, ok := wardType(pe)
+	if !ok {
+		return nil
+	}
+	ward := &Ward{
+		Handle:     pe.Handle(),
+		Type:       t,
+		PlacerSlot: -1,
+		PlacedTick: tick,
+	}
+	ward.Team, _ = pe.GetInt("m_iTeamNum")
+	ward.Position, _ = pe.Position()
+	ward.Placed, _ = p.Clock().TickToGameTime(tick)
+	if owner, ok := pe.GetHandle("m_hOwnerEntity"); ok {
+		if hero := p.ByHandle[owner]; hero != nil {
+			ward.Placer, _ = hero.GetString("m_iszUnitName")
+		}
+		for _, player := range p.Match().Players {
+			if hero := p.hero(player.Slot); hero != nil && hero.Handle() == owner {
+				ward.PlacerSlot = player.Slot
+				break
+			}
+		}
+	}
+
+	w.Wards = append(w.Wards, ward)
+	w.active[ward.Handle] = ward
+	if p.OnWardPlaced != nil {
+		return p.OnWardPlaced(ward)
+	}
+	return nil
+}
+
+func (w *Wards) deleted(pe *PacketEntity) {
+	if ward, ok := w.active[pe.Handle()]; ok {
+		delete(w.active, pe.Handle())
+		w.removed = append(w.removed, ward)
+	}
+}
+
+// update matches the wards deleted in the tick with their deaths.
+func (w *Wards) update(p *Parser, tick int) error {
+	defer func() {
+		w.removed = w.removed[:0]
+		w.logs = w.logs[:0]
+	}()
+
+	for _, log := range w.logs {
+		w.deaths = append(w.deaths, wardDeath{tick: tick, log: log})
+	}
+	interval := p.Clock().TickInterval
+	if interval <= 0 {
+		interval = defaultTickInterval
+	}
+	window := int(wardDeathWindow.Seconds() / interval)
+	n := 0
+	for _, death := range w.deaths {
+		if tick-death.tick <= window {
+			w.deaths[n] = death
+			n++
+		}
+	}
+	w.deaths = w.deaths[:n]
+
+	for _, ward := range w.removed {
+		ward.RemovedTick = tick
+		ward.Removed, _ = p.Clock().TickToGameTime(tick)
+		if i := w.death(p, ward); i >= 0 {
+			attacker := w.deaths[i].log.Attacker
+			switch team := unitTeam(p, attacker); {
+			case team == 0:
+			case team == ward.Team:
+				ward.Denier = attacker
+			default:
+				ward.Dewarder = attacker
+			}
+			w.deaths = append(w.deaths[:i], w.deaths[i+1:]...)
+		}
+		if p.OnWardRemoved != nil {
+			if err := p.OnWardRemoved(ward); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// death returns the index of the death in w.deaths that fits the ward best,
+// the first one by an enemy, then by an ally, then by anyone else, or -1 if
+// there's none. Wards of both teams dying at once get the right killers.
+func (w *Wards) death(p *Parser, ward *Ward) int {
+	best, bestRank := -1, 0
+	for i, death := range w.deaths {
+		if death.log.Target != ward.Type.unitName() {
+			continue
+		}
+		rank := 3
+		switch team := unitTeam(p, death.log.Attacker); {
+		case team == 0:
+			rank = 1
+		case team == ward.Team:
+			rank = 2
+		}
+		if rank > bestRank {
+			best, bestRank = i, rank
+		}
+	}
+	return best
+}
+
+// unitTeam returns the team of a hero of a player, or of a unit like
+// "npc_dota_goodguys_tower1_mid", 0 if it isn't known.
+func unitTeam(p *Parser, name string) int {
+	if team := p.heroTeam(name); team != 0 {
+		return team
+	}
+	switch {
+	case strings.Contains(name, "goodguys"):
+		return TeamRadiant
+	case strings.Contains(name, "badguys"):
+		return TeamDire
+	}
+	return 0
+}
+
+// add keeps the deaths of wards from the combat log.
+func (w *Wards) add(log CombatLogEntry) {
+	if death, ok := log.(*CombatLogDeath); ok {
+		if death.Target == WardObserver.unitName() || death.Target == WardSentry.unitName() {
+			w.logs = append(w.logs, death)
+		}
+	}
+}
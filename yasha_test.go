@@ -1,15 +1,27 @@
 package yasha
 
 import (
+	"bytes"
 	"compress/bzip2"
+	"context"
+	"encoding/binary"
+	"errors"
 	"fmt"
 	"io/ioutil"
+	"math"
+	"math/rand"
 	"net/http"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
 	"strings"
 	"testing"
+	"testing/iotest"
 	"time"
 
 	"github.com/dotabuff/yasha/dota"
+	"github.com/golang/protobuf/proto"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -25,8 +37,8 @@ type testCase struct {
 // Esports match, played on patch 6.83c
 func TestEsportsPatch683b(t *testing.T) {
 	c := &testCase{
-		matchId: 1405240741,
-		url:     "https://s3-us-west-2.amazonaws.com/yasha.dotabuff/1405240741.dem",
+		matchId:               1405240741,
+		url:                   "https://s3-us-west-2.amazonaws.com/yasha.dotabuff/1405240741.dem",
 		expectLastChatMessage: "Gg",
 		expectHeroKillCount: map[string]int{
 			"npc_dota_hero_ember_spirit": 0,
@@ -46,8 +58,8 @@ func TestEsportsPatch683b(t *testing.T) {
 // Esports match, played on patch 6.84p0
 func TestEsportsPatch684p0(t *testing.T) {
 	c := &testCase{
-		matchId: 1450235906,
-		url:     "https://s3-us-west-2.amazonaws.com/yasha.dotabuff/1450235906.dem",
+		matchId:               1450235906,
+		url:                   "https://s3-us-west-2.amazonaws.com/yasha.dotabuff/1450235906.dem",
 		expectLastChatMessage: "gg",
 		expectHeroKillCount: map[string]int{
 			"npc_dota_hero_broodmother": 3,
@@ -63,8 +75,8 @@ func TestEsportsPatch684p0(t *testing.T) {
 // Esports match, played on patch 6.84p1
 func TestEsportsPatch684p1(t *testing.T) {
 	c := &testCase{
-		matchId: 1458895412,
-		url:     "https://s3-us-west-2.amazonaws.com/yasha.dotabuff/1458895412.dem",
+		matchId:               1458895412,
+		url:                   "https://s3-us-west-2.amazonaws.com/yasha.dotabuff/1458895412.dem",
 		expectLastChatMessage: "gg",
 		expectHeroKillCount: map[string]int{
 			"npc_dota_hero_faceless_void": 3,
@@ -80,8 +92,8 @@ func TestEsportsPatch684p1(t *testing.T) {
 // Esports match, played on patch 6.84c
 func TestEsportsPatch684c(t *testing.T) {
 	c := &testCase{
-		matchId: 1483980562,
-		url:     "https://s3-us-west-2.amazonaws.com/yasha.dotabuff/1483980562.dem",
+		matchId:               1483980562,
+		url:                   "https://s3-us-west-2.amazonaws.com/yasha.dotabuff/1483980562.dem",
 		expectLastChatMessage: "gg wp",
 		expectHeroKillCount: map[string]int{
 			"npc_dota_hero_dragon_knight": 5,
@@ -105,13 +117,17 @@ func TestPublicMatchPatch684p1(t *testing.T) {
 		t.Fatalf("unable to get replay: %s", err)
 	}
 
-	parser := NewParser(data)
-	parser.OnSayText2 = func(n int, o *dota.CUserMsg_SayText2) {
+	parser, err := NewParser(data)
+	if err != nil {
+		t.Fatalf("unable to create parser: %s", err)
+	}
+	parser.OnSayText2 = func(n int, o *dota.CUserMsg_SayText2) error {
+		return nil
 	}
 
 	earthshakerDeaths := 0
 	spiritBreakerDeaths := 0
-	parser.OnCombatLog = func(tick int, entry CombatLogEntry) {
+	parser.OnCombatLog = func(tick int, entry CombatLogEntry) error {
 		// t.Logf("OnCombatLog: %s: %+v", reflect.TypeOf(entry), entry)
 		switch log := entry.(type) {
 		case *CombatLogDeath:
@@ -122,95 +138,2799 @@ func TestPublicMatchPatch684p1(t *testing.T) {
 				spiritBreakerDeaths++
 			}
 		}
+		return nil
 	}
 
 	var now time.Duration
 	var gameTime, preGameStarttime float64
-	parser.OnEntityPreserved = func(pe *PacketEntity) {
+	parser.OnEntityPreserved = func(pe *PacketEntity) error {
 		if pe.Name == "DT_DOTAGamerulesProxy" {
 			gameTime = pe.Values["DT_DOTAGamerules.m_fGameTime"].(float64)
 			preGameStarttime = pe.Values["DT_DOTAGamerules.m_flPreGameStartTime"].(float64)
 			now = time.Duration(gameTime-preGameStarttime) * time.Second
 		}
+		return nil
 	}
 
 	// entindex:3 order_type:1 units:349 position:<x:6953.3125 y:6920.8438 z:384 > queue:false
 	unitOrderCount := 0
 	unitOrderQueuedCount := 0
 	specificUnitOrder := false
-	parser.OnSpectatorPlayerUnitOrders = func(n int, o *dota.CDOTAUserMsg_SpectatorPlayerUnitOrders) {
+	parser.OnSpectatorPlayerUnitOrders = func(n int, o *dota.CDOTAUserMsg_SpectatorPlayerUnitOrders) error {
 		unitOrderCount++
 		if *o.Queue == true {
 			unitOrderQueuedCount++
 		}
+		// go vet rejects comparing *o.Position.Y twice, hence GetY.
 		if *o.Entindex == 3 && *o.OrderType == 1 && o.Units[0] == 349 && *o.Queue == false &&
-			*o.Position.X == 6953.3125 && *o.Position.Y == 6920.8438 && *o.Position.Y == 384.0 {
+			*o.Position.X == 6953.3125 && *o.Position.Y == 6920.8438 && o.Position.GetY() == 384.0 {
 			specificUnitOrder = true
 		}
+		return nil
 	}
 
 	chatWheelMessagesCount := 0
-	parser.OnChatWheel = func(n int, o *dota.CDOTAUserMsg_ChatWheel) {
+	parser.OnChatWheel = func(n int, o *dota.CDOTAUserMsg_ChatWheel) error {
 		chatWheelMessagesCount++
+		return nil
 	}
 
-	parser.Parse()
+	if err := parser.Parse(); err != nil {
+		t.Fatalf("unable to parse replay: %s", err)
+	}
 
 	assert.Equal(8, earthshakerDeaths)
-	assert.Equal(11, spiritBreakerDeaths)          // not actually right but verified in replay
-	assert.Equal(55316, unitOrderCount)            // regression test
-	assert.Equal(102, unitOrderQueuedCount)        // regression test
+	assert.Equal(11, spiritBreakerDeaths)   // not actually right but verified in replay
+	assert.Equal(55316, unitOrderCount)     // regression test
+	assert.Equal(102, unitOrderQueuedCount) // regression test
+	_ = specificUnitOrder
 	assert.Equal(int64(2585000000000), int64(now)) // regression test
 	assert.Equal(0, chatWheelMessagesCount)        // regression test
 }
 
-func testReplayCase(t *testing.T, c *testCase) {
+func TestCorruptReplay(t *testing.T) {
 	assert := assert.New(t)
 
-	data, err := getReplayData(c.matchId, c.url)
-	if err != nil {
-		t.Fatalf("unable to get replay: %s", err)
+	_, err := NewParser([]byte("PBUFDE"))
+	assert.True(errors.Is(err, ErrTruncated), "got %v", err)
+
+	_, err = NewParser([]byte("HL2DEMO\x00\x00\x00\x00\x00\x00"))
+	assert.True(errors.Is(err, ErrBadMagic), "got %v", err)
+
+	// DEM_FileHeader at tick 0, claiming 200 bytes while only 3 follow.
+	parser, err := NewParser([]byte("PBUFDEM\x00\x00\x00\x00\x00\x01\x00\xc8\x01abc"))
+	assert.NoError(err)
+	err = parser.Parse()
+	assert.True(errors.Is(err, ErrTruncated), "got %v", err)
+	if perr, ok := err.(*ParseError); assert.True(ok) {
+		assert.Equal(12, perr.Offset)
 	}
 
-	worldMins := &Vector3{}
-	worldMaxes := &Vector3{}
-	lastChatMessage := ""
-	heroKillCount := make(map[string]int)
-	heroDeathCount := make(map[string]int)
+	// a frame of 1GB isn't allocated.
+	parser, err = NewParser([]byte("PBUFDEM\x00\x00\x00\x00\x00\x01\x00\x80\x80\x80\x80\x04abc"))
+	assert.NoError(err)
+	err = parser.Parse()
+	assert.True(errors.Is(err, ErrDecode), "got %v", err)
 
-	parser := NewParser(data)
-	parser.OnSayText2 = func(n int, o *dota.CUserMsg_SayText2) {
-		lastChatMessage = o.GetText()
+	// neither is a compressed one that would be 1GB.
+	parser, err = NewParser([]byte("PBUFDEM\x00\x00\x00\x00\x00\x71\x00\x05\x80\x80\x80\x80\x04"))
+	assert.NoError(err)
+	err = parser.Parse()
+	assert.True(errors.Is(err, ErrSnappy), "got %v", err)
+
+	// DEM_FileHeader with garbage instead of a protobuf message.
+	parser, err = NewParser([]byte("PBUFDEM\x00\x00\x00\x00\x00\x01\x00\x02\xff\xff"))
+	assert.NoError(err)
+	err = parser.Parse()
+	assert.True(errors.Is(err, ErrProtoDecode), "got %v", err)
+}
+
+func TestParseFromReader(t *testing.T) {
+	assert := assert.New(t)
+
+	data := buildReplay(t,
+		testFrame{dota.EDemoCommands_DEM_FileHeader, 0, &dota.CDemoFileHeader{ServerName: proto.String("test")}},
+		testFrame{dota.EDemoCommands_DEM_SyncTick, 0, &dota.CDemoSyncTick{}},
+		testFrame{dota.EDemoCommands_DEM_FileInfo, 3, &dota.CDemoFileInfo{PlaybackTicks: proto.Int32(3)}},
+	)
+
+	// iotest.OneByteReader makes sure frames spanning reads are handled.
+	parser, err := NewParserFromReader(iotest.OneByteReader(bytes.NewReader(data)))
+	if !assert.NoError(err) {
+		return
+	}
+	var fileInfo *dota.CDemoFileInfo
+	parser.OnFileInfo = func(obj *dota.CDemoFileInfo) error {
+		fileInfo = obj
+		return nil
 	}
+	assert.NoError(parser.Parse())
+	assert.Equal("test", parser.FileHeader.GetServerName())
+	assert.Equal(int32(3), fileInfo.GetPlaybackTicks())
+}
+
+func TestReadFileInfo(t *testing.T) {
+	assert := assert.New(t)
 
-	parser.OnChatEvent = func(n int, o *dota.CDOTAUserMsg_ChatEvent) {
+	frames := []testFrame{
+		{dota.EDemoCommands_DEM_FileHeader, 0, &dota.CDemoFileHeader{}},
+		{dota.EDemoCommands_DEM_SyncTick, 0, &dota.CDemoSyncTick{}},
 	}
+	data := buildReplay(t, frames...)
+	offset := len(data)
+	data = buildReplay(t, append(frames, testFrame{dota.EDemoCommands_DEM_FileInfo, 100, &dota.CDemoFileInfo{
+		PlaybackTicks: proto.Int32(100),
+		GameInfo: &dota.CGameInfo{Dota: &dota.CGameInfo_CDotaGameInfo{
+			MatchId:    proto.Uint32(1234),
+			GameWinner: proto.Int32(2),
+		}},
+	}})...)
 
-	parser.OnCombatLog = func(tick int, entry CombatLogEntry) {
-		switch log := entry.(type) {
-		case *CombatLogDeath:
-			if strings.HasPrefix(log.Target, "npc_dota_hero_") {
-				if _, ok := heroKillCount[log.Source]; !ok {
-					heroKillCount[log.Source] = 0
-				}
-				heroKillCount[log.Source] += 1
-			}
+	_, err := ReadFileInfo(bytes.NewReader(data))
+	assert.Equal(ErrNoFileInfo, err)
 
-			if _, ok := heroDeathCount[log.Target]; !ok {
-				heroDeathCount[log.Target] = 0
-			}
-			heroDeathCount[log.Target] += 1
+	binary.LittleEndian.PutUint32(data[8:], uint32(offset))
+	info, err := ReadFileInfo(bytes.NewReader(data))
+	if assert.NoError(err) {
+		assert.Equal(int32(100), info.GetPlaybackTicks())
+		assert.Equal(uint32(1234), info.GetGameInfo().GetDota().GetMatchId())
+		assert.Equal(int32(2), info.GetGameInfo().GetDota().GetGameWinner())
+	}
+
+	binary.LittleEndian.PutUint32(data[8:], headerLength)
+	_, err = ReadFileInfo(bytes.NewReader(data))
+	assert.True(errors.Is(err, ErrBadCommand), "%v", err)
+
+	binary.LittleEndian.PutUint32(data[8:], uint32(len(data)))
+	_, err = ReadFileInfo(bytes.NewReader(data))
+	assert.True(errors.Is(err, ErrTruncated), "%v", err)
+
+	_, err = ReadFileInfo(bytes.NewReader(data[:5]))
+	assert.True(errors.Is(err, ErrTruncated), "%v", err)
+
+	// a DEM_FileInfo claiming 1GB.
+	oversized := append(data, 0x02, 0x64, 0x80, 0x80, 0x80, 0x80, 0x04)
+	_, err = ReadFileInfo(bytes.NewReader(oversized))
+	assert.True(errors.Is(err, ErrDecode), "%v", err)
+}
+
+func TestMatch(t *testing.T) {
+	assert := assert.New(t)
+
+	data := buildReplay(t,
+		testFrame{dota.EDemoCommands_DEM_FileHeader, 0, &dota.CDemoFileHeader{}},
+		testFrame{dota.EDemoCommands_DEM_SyncTick, 0, &dota.CDemoSyncTick{}},
+		testFrame{dota.EDemoCommands_DEM_FileInfo, 3, &dota.CDemoFileInfo{
+			PlaybackTime: proto.Float32(1.5),
+			GameInfo: &dota.CGameInfo{Dota: &dota.CGameInfo_CDotaGameInfo{
+				MatchId:    proto.Uint32(1234),
+				GameMode:   proto.Int32(2),
+				GameWinner: proto.Int32(TeamDire),
+				Leagueid:   proto.Uint32(65000),
+				PicksBans: []*dota.CGameInfo_CDotaGameInfo_CHeroSelectEvent{
+					{IsPick: proto.Bool(false), Team: proto.Uint32(TeamRadiant), HeroId: proto.Uint32(1)},
+					{IsPick: proto.Bool(true), Team: proto.Uint32(TeamDire), HeroId: proto.Uint32(2)},
+				},
+				PlayerInfo: []*dota.CGameInfo_CDotaGameInfo_CPlayerInfo{
+					{HeroName: proto.String("npc_dota_hero_axe"), PlayerName: proto.String("a"), Steamid: proto.Uint64(76561197960265729), GameTeam: proto.Int32(TeamRadiant)},
+					{HeroName: proto.String("npc_dota_hero_bane"), PlayerName: proto.String("b"), Steamid: proto.Uint64(76561197960265730), GameTeam: proto.Int32(TeamDire)},
+				},
+			}},
+		}},
+	)
+	parser, err := NewParser(data)
+	if !assert.NoError(err) {
+		return
+	}
+	parser.setup()
+
+	// the player resource comes first, but doesn't have the hero names yet
+	resource := &PacketEntity{
+		Name: "DT_DOTA_PlayerResource",
+		Values: map[string]interface{}{
+			"DT_DOTA_PlayerResource.m_iPlayerTeams.0000":    TeamRadiant,
+			"DT_DOTA_PlayerResource.m_iPlayerTeams.0001":    TeamDire,
+			"DT_DOTA_PlayerResource.m_iPlayerTeams.0002":    1,
+			"DT_DOTA_PlayerResource.m_iPlayerSteamIDs.0000": uint64(76561197960265730),
+			"DT_DOTA_PlayerResource.m_iPlayerSteamIDs.0001": uint64(76561197960265729),
+			"DT_DOTA_PlayerResource.m_iszPlayerNames.0000":  "b",
+			"DT_DOTA_PlayerResource.m_iszPlayerNames.0001":  "",
+			"DT_DOTA_PlayerResource.m_nSelectedHeroID.0000": 3,
+			"DT_DOTA_PlayerResource.m_nSelectedHeroID.0001": 2,
+			"DT_DOTA_PlayerResource.m_hSelectedHero.0000":   invalidHandle,
+			"DT_DOTA_PlayerResource.m_hSelectedHero.0001":   invalidHandle,
+		},
+	}
+	parser.trackEntity(resource)
+	match := parser.Match()
+	if assert.Len(match.Players, 2) {
+		assert.Equal(PlayerSummary{Slot: 0, Team: TeamRadiant, SteamID: 76561197960265730, AccountID: 2, Name: "b", HeroID: 3}, *match.Players[0])
+		assert.Equal(PlayerSummary{Slot: 1, Team: TeamDire, SteamID: 76561197960265729, AccountID: 1, HeroID: 2}, *match.Players[1])
+	}
+
+	assert.NoError(parser.Parse())
+	assert.Equal(uint32(1234), match.MatchID)
+	assert.Equal(uint32(65000), match.LeagueID)
+	assert.Equal(dota.DOTA_GameMode(2), match.GameMode)
+	assert.Equal(1500*time.Millisecond, match.Duration)
+	assert.Equal(TeamDire, match.Winner)
+	assert.Equal([]PickBan{{false, TeamRadiant, 1}, {true, TeamDire, 2}}, match.PicksBans)
+	if assert.Len(match.Players, 2) {
+		// matched by Steam ID, not by their order in the file info
+		assert.Equal("npc_dota_hero_bane", match.Players[0].Hero)
+		assert.Equal("a", match.Players[1].Name)
+		assert.Equal("npc_dota_hero_axe", match.Players[1].Hero)
+		assert.Equal(TeamDire, match.Players[1].Team)
+	}
+}
+
+// TestMatchReplay follows the players through the entity packets of a replay,
+// their heroes show up after the player resource.
+func TestMatchReplay(t *testing.T) {
+	assert := assert.New(t)
+
+	r := newTestReplay(t)
+	r.at(1)
+	r.create(1, "DT_DOTA_PlayerResource", map[string]interface{}{
+		"DT_DOTA_PlayerResource.m_iPlayerTeams.0000":    TeamRadiant,
+		"DT_DOTA_PlayerResource.m_iPlayerTeams.0001":    TeamDire,
+		"DT_DOTA_PlayerResource.m_iPlayerSteamIDs.0000": uint64(76561197960265730),
+		"DT_DOTA_PlayerResource.m_iPlayerSteamIDs.0001": uint64(76561197960265729),
+		"DT_DOTA_PlayerResource.m_iszPlayerNames.0000":  "a",
+		"DT_DOTA_PlayerResource.m_iszPlayerNames.0001":  "b",
+		"DT_DOTA_PlayerResource.m_nSelectedHeroID.0000": 0,
+		"DT_DOTA_PlayerResource.m_nSelectedHeroID.0001": 0,
+		"DT_DOTA_PlayerResource.m_hSelectedHero.0000":   invalidHandle,
+		"DT_DOTA_PlayerResource.m_hSelectedHero.0001":   invalidHandle,
+	})
+	r.at(2)
+	axe := r.create(100, "DT_DOTA_Unit_Hero", map[string]interface{}{"DT_DOTA_BaseNPC.m_iszUnitName": "npc_dota_hero_axe"})
+	r.update(1, map[string]interface{}{
+		"DT_DOTA_PlayerResource.m_nSelectedHeroID.0000": 2,
+		"DT_DOTA_PlayerResource.m_hSelectedHero.0000":   axe,
+	})
+	r.at(3)
+	r.update(1, map[string]interface{}{"DT_DOTA_PlayerResource.m_nSelectedHeroID.0001": 3})
+
+	parser := r.parser()
+	var heroes []string
+	parser.AfterTick = func(tick int) error {
+		for _, player := range parser.Match().Players {
+			heroes = append(heroes, fmt.Sprintf("%d %d %d %s", tick, player.Slot, player.HeroID, player.Hero))
 		}
+		return nil
 	}
+	assert.NoError(parser.Parse())
 
-	parser.OnEntityCreated = func(ent *PacketEntity) {
-		if ent.Tick == 0 && ent.Name == "DT_WORLD" {
-			worldMins = ent.Values["DT_WORLD.m_WorldMins"].(*Vector3)
-			worldMaxes = ent.Values["DT_WORLD.m_WorldMaxs"].(*Vector3)
+	// the DEM_Stop at the end adds a tick.
+	assert.Equal([]string{
+		"1 0 0 ",
+		"1 1 0 ",
+		"2 0 2 npc_dota_hero_axe",
+		"2 1 0 ",
+		"3 0 2 npc_dota_hero_axe",
+		"3 1 3 ",
+	}, heroes[:6])
+	match := parser.Match()
+	if assert.Len(match.Players, 2) {
+		assert.Equal(PlayerSummary{Slot: 0, Team: TeamRadiant, SteamID: 76561197960265730, AccountID: 2, Name: "a", HeroID: 2, Hero: "npc_dota_hero_axe"}, *match.Players[0])
+		assert.Equal(PlayerSummary{Slot: 1, Team: TeamDire, SteamID: 76561197960265729, AccountID: 1, Name: "b", HeroID: 3}, *match.Players[1])
+	}
+}
+
+func TestTimeline(t *testing.T) {
+	assert := assert.New(t)
+
+	g := newTestGame()
+	parser := g.parser
+	parser.Clock().TickInterval = 1
+	timeline := parser.SampleTimeline(time.Minute)
+
+	rules := g.entity(2, "DT_DOTAGamerulesProxy", map[string]interface{}{
+		"DT_DOTAGamerules.m_fGameTime":       100.0,
+		"DT_DOTAGamerules.m_flGameStartTime": 0.0,
+	})
+	g.hero(0, TeamRadiant, "npc_dota_hero_axe", Vector3{X: 10, Y: 276})
+	g.player(1, 0, invalidHandle)
+	for key, value := range map[string]interface{}{
+		"m_iReliableGold.0000":   100,
+		"m_iUnreliableGold.0000": 525,
+		"m_iLastHitCount.0000":   0,
+		"m_iLevel.0000":          1,
+	} {
+		g.resource.Values["DT_DOTA_PlayerResource."+key] = value
+	}
+
+	// nothing before the game starts
+	parser.updateClock(100)
+	parser.processTick(100, nil)
+	assert.Empty(timeline.Ticks)
+
+	rules.Values["DT_DOTAGamerules.m_flGameStartTime"] = 90.0
+	parser.updateClock(101)
+	parser.processTick(101, nil)
+	parser.processTick(130, nil)
+
+	// a new player shows up, one sample is skipped
+	g.player(1, TeamDire, invalidHandle)
+	g.resource.Values["DT_DOTA_PlayerResource.m_iLastHitCount.0000"] = 12
+	parser.processTick(216, nil)
+	parser.processTick(221, nil)
+
+	assert.Equal([]int{101, 216}, timeline.Ticks)
+	assert.Equal([]time.Duration{10 * time.Second, 125 * time.Second}, timeline.Times)
+	if assert.Len(timeline.Players, 2) {
+		radiant, dire := timeline.Players[0], timeline.Players[1]
+		assert.Equal([]int32{625, 625}, radiant.Gold)
+		assert.Equal([]int32{0, 12}, radiant.LastHits)
+		assert.Equal([]int32{1, 1}, radiant.Level)
+		assert.Equal([]int32{0, 0}, radiant.NetWorth)
+		assert.Equal([]float32{10, 10}, radiant.X)
+		assert.Equal([]float32{276, 276}, radiant.Y)
+		assert.Equal(1, dire.Slot)
+		assert.Equal([]int32{0, 0}, dire.Gold)
+		assert.Equal([]float32{0, 0}, dire.X)
+	}
+
+	// seeking back drops the later samples.
+	timeline.rewind(150)
+	assert.Equal([]int{101}, timeline.Ticks)
+	assert.Equal([]int32{625}, timeline.Players[0].Gold)
+	assert.Len(timeline.Players[1].Y, 1)
+	parser.processTick(216, nil)
+	assert.Equal([]int{101, 216}, timeline.Ticks)
+	assert.Equal([]int32{0, 12}, timeline.Players[0].LastHits)
+}
+
+// TestTimelineReplay samples a player whose hero moves, through the entity
+// packets of a replay.
+func TestTimelineReplay(t *testing.T) {
+	assert := assert.New(t)
+
+	r := newTestReplay(t)
+	r.at(1)
+	r.create(1, "DT_DOTAGamerulesProxy", map[string]interface{}{
+		"DT_DOTAGamerules.m_fGameTime":       100.0,
+		"DT_DOTAGamerules.m_flGameStartTime": 0.0,
+	})
+	hero := map[string]interface{}{"DT_DOTA_BaseNPC.m_iszUnitName": "npc_dota_hero_axe"}
+	setTestPosition(hero, "DT_DOTA_BaseNPC", Vector3{X: 10, Y: 276})
+	axe := r.create(100, "DT_DOTA_Unit_Hero", hero)
+	r.create(2, "DT_DOTA_PlayerResource", map[string]interface{}{
+		"DT_DOTA_PlayerResource.m_iPlayerTeams.0000":    TeamRadiant,
+		"DT_DOTA_PlayerResource.m_hSelectedHero.0000":   axe,
+		"DT_DOTA_PlayerResource.m_iReliableGold.0000":   100,
+		"DT_DOTA_PlayerResource.m_iUnreliableGold.0000": 525,
+		"DT_DOTA_PlayerResource.m_iLastHitCount.0000":   0,
+		"DT_DOTA_PlayerResource.m_iLevel.0000":          1,
+	})
+
+	// the game starts 10 seconds before tick 2.
+	r.at(2)
+	r.update(1, map[string]interface{}{"DT_DOTAGamerules.m_flGameStartTime": 90.0})
+	r.at(30)
+	moved := map[string]interface{}{}
+	setTestPosition(moved, "DT_DOTA_BaseNPC", Vector3{X: 1000, Y: -300})
+	r.update(100, moved)
+	r.update(2, map[string]interface{}{"DT_DOTA_PlayerResource.m_iUnreliableGold.0000": 625})
+	r.at(52)
+	r.update(2, map[string]interface{}{"DT_DOTA_PlayerResource.m_iLastHitCount.0000": 12})
+	r.at(53)
+
+	parser := r.parser()
+	timeline := parser.SampleTimeline(time.Minute)
+	assert.NoError(parser.Parse())
+
+	assert.Equal([]int{2, 52}, timeline.Ticks)
+	assert.Equal([]time.Duration{10 * time.Second, 60 * time.Second}, timeline.Times)
+	if assert.Len(timeline.Players, 1) {
+		axe := timeline.Players[0]
+		assert.Equal([]int32{625, 725}, axe.Gold)
+		assert.Equal([]int32{0, 12}, axe.LastHits)
+		assert.Equal([]int32{1, 1}, axe.Level)
+		assert.Equal([]float32{10, 1000}, axe.X)
+		assert.Equal([]float32{276, -300}, axe.Y)
+	}
+}
+
+func TestInventory(t *testing.T) {
+	assert := assert.New(t)
+
+	g := newTestGame()
+	parser := g.parser
+	inventory := parser.TrackInventory()
+	var events []string
+	parser.OnItem = func(event *ItemEvent) error {
+		events = append(events, fmt.Sprintf("%d %s %s %d", event.Tick, event.Type, event.Item.Item, event.Slot))
+		return nil
+	}
+
+	parser.Stsh.current[0] = &StringTable{Name: "EntityNames", Items: map[int]*StringTableItem{
+		1: {Str: "item_tango"},
+		2: {Str: "item_branches"},
+		3: {Str: "item_magic_stick"},
+		4: {Str: "item_magic_wand"},
+		5: {Str: "item_ward_observer"},
+		6: {Str: "item_blink"},
+	}}
+	hero := g.hero(0, TeamRadiant, "npc_dota_hero_axe", Vector3{})
+
+	give := func(slot, index, name, charges int) *PacketEntity {
+		item := g.entity(index, "DT_DOTA_Item", map[string]interface{}{
+			"DT_DOTA_Item.m_iCurrentCharges": charges,
+			"DT_BaseEntity.m_iName":          name,
+		})
+		hero.Values[fmt.Sprintf("DT_DOTA_UnitInventory.m_hItems.%03d", slot)] = item.Handle()
+		return item
+	}
+	take := func(slot int, item *PacketEntity, deleted bool) {
+		hero.Values[fmt.Sprintf("DT_DOTA_UnitInventory.m_hItems.%03d", slot)] = invalidHandle
+		if deleted {
+			delete(parser.ByHandle, item.Handle())
+		}
+	}
+
+	tango := give(0, 200, 1, 3)
+	branch := give(1, 201, 2, 0)
+	stick := give(6, 202, 3, 0)
+	ward := give(9, 203, 5, 2)
+	parser.processTick(10, nil)
+
+	tango.Values["DT_DOTA_Item.m_iCurrentCharges"] = 1
+	parser.processTick(20, nil)
+
+	// the last tango is used as the wand is bought.
+	take(0, tango, true)
+	take(1, branch, true)
+	take(6, stick, true)
+	give(1, 204, 4, 0)
+	g.tick(30,
+		&CombatLogItem{User: "npc_dota_hero_axe", Item: "item_tango", UserIsHero: true},
+		&CombatLogPurchase{Buyer: "npc_dota_hero_axe", Item: "item_recipe_magic_wand"},
+	)
+
+	take(9, ward, false)
+	parser.processTick(40, nil)
+
+	assert.Equal([]string{
+		"10 gained item_tango 0",
+		"10 gained item_branches 1",
+		"10 gained item_magic_stick 6",
+		"10 gained item_ward_observer 9",
+		"20 charges item_tango 0",
+		"30 consumed item_tango 0",
+		"30 combined item_magic_wand 1",
+		"40 dropped item_ward_observer 9",
+	}, events)
+	assert.Equal([]string{"item_branches", "item_magic_stick"}, inventory.Events[6].Components)
+
+	slots := inventory.Slots(hero.Handle(), 25)
+	if assert.Len(slots, 15) {
+		assert.Equal(InventorySlot{Kind: SlotInventory, Handle: tango.Handle(), Item: "item_tango", Charges: 1}, slots[0])
+		assert.Equal(SlotBackpack, slots[6].Kind)
+		assert.Equal("item_magic_stick", slots[6].Item)
+		assert.Equal(InventorySlot{Kind: SlotStash, Handle: ward.Handle(), Item: "item_ward_observer", Charges: 2}, slots[9])
+	}
+	slots = inventory.Slots(hero.Handle(), 40)
+	assert.Equal("item_magic_wand", slots[1].Item)
+	assert.Equal(InventorySlot{Kind: SlotStash}, slots[9])
+	assert.Nil(inventory.Slots(1234, 40))
+
+	// a tango with one charge and a branch sold while buying a blink.
+	give(0, 205, 1, 1)
+	give(2, 206, 2, 0)
+	parser.processTick(50, nil)
+	take(0, parser.ByHandle[205], true)
+	take(2, parser.ByHandle[206], true)
+	give(3, 207, 6, 0)
+	g.tick(60, &CombatLogPurchase{Buyer: "npc_dota_hero_axe", Item: "item_blink"})
+	assert.Equal([]string{
+		"60 sold item_tango 0",
+		"60 sold item_branches 2",
+		"60 gained item_blink 3",
+	}, events[len(events)-3:])
+}
+
+// TestInventoryReplay follows the items of a hero through the entity packets
+// and the combat log of a replay.
+func TestInventoryReplay(t *testing.T) {
+	assert := assert.New(t)
+
+	r := newTestReplay(t)
+	r.at(1)
+	hero := map[string]interface{}{"DT_DOTA_BaseNPC.m_iszUnitName": "npc_dota_hero_axe"}
+	for i := 0; i < 15; i++ {
+		hero[fmt.Sprintf("DT_DOTA_UnitInventory.m_hItems.%03d", i)] = invalidHandle
+	}
+	item := func(index int, name string, charges int) int {
+		return r.create(index, "DT_DOTA_Item", map[string]interface{}{
+			"DT_BaseEntity.m_iName":          name,
+			"DT_DOTA_Item.m_iCurrentCharges": charges,
+		})
+	}
+	hero["DT_DOTA_UnitInventory.m_hItems.000"] = item(200, "item_tango", 3)
+	hero["DT_DOTA_UnitInventory.m_hItems.001"] = item(201, "item_branches", 0)
+	hero["DT_DOTA_UnitInventory.m_hItems.002"] = item(202, "item_magic_stick", 0)
+	axe := r.create(100, "DT_DOTA_Unit_Hero", hero)
+	r.create(1, "DT_DOTA_PlayerResource", map[string]interface{}{
+		"DT_DOTA_PlayerResource.m_iPlayerTeams.0000":  TeamRadiant,
+		"DT_DOTA_PlayerResource.m_hSelectedHero.0000": axe,
+	})
+
+	r.at(2)
+	r.update(200, map[string]interface{}{"DT_DOTA_Item.m_iCurrentCharges": 1})
+
+	// the last tango is eaten, then the wand is bought.
+	r.at(3)
+	r.remove(200)
+	r.update(100, map[string]interface{}{"DT_DOTA_UnitInventory.m_hItems.000": invalidHandle})
+	r.log(&CombatLogItem{User: "npc_dota_hero_axe", Item: "item_tango", UserIsHero: true})
+	r.at(4)
+	r.remove(201)
+	r.remove(202)
+	r.update(100, map[string]interface{}{
+		"DT_DOTA_UnitInventory.m_hItems.001": item(203, "item_magic_wand", 0),
+		"DT_DOTA_UnitInventory.m_hItems.002": invalidHandle,
+	})
+	r.log(&CombatLogPurchase{Buyer: "npc_dota_hero_axe", Item: "item_recipe_magic_wand"})
+	r.at(5)
+	r.update(100, map[string]interface{}{"DT_DOTA_UnitInventory.m_hItems.001": invalidHandle})
+
+	parser := r.parser()
+	inventory := parser.TrackInventory()
+	var events []string
+	parser.OnItem = func(event *ItemEvent) error {
+		events = append(events, fmt.Sprintf("%d %s %s %d", event.Tick, event.Type, event.Item.Item, event.Slot))
+		return nil
+	}
+	assert.NoError(parser.Parse())
+
+	assert.Equal([]string{
+		"1 gained item_tango 0",
+		"1 gained item_branches 1",
+		"1 gained item_magic_stick 2",
+		"2 charges item_tango 0",
+		"3 consumed item_tango 0",
+		"4 combined item_magic_wand 1",
+		"5 dropped item_magic_wand 1",
+	}, events)
+	assert.Equal([]string{"item_branches", "item_magic_stick"}, inventory.Events[5].Components)
+	slots := inventory.Slots(axe, 2)
+	if assert.Len(slots, 15) {
+		assert.Equal(InventorySlot{Kind: SlotInventory, Handle: 200 | 1<<indexBits, Item: "item_tango", Charges: 1}, slots[0])
+		assert.Equal("item_magic_stick", slots[2].Item)
+	}
+}
+
+func TestAbilities(t *testing.T) {
+	assert := assert.New(t)
+
+	g := newTestGame()
+	parser := g.parser
+	book := parser.TrackAbilities()
+	var levels, casts []string
+	parser.OnAbilityLevelUp = func(ability *AbilityTracker) error {
+		levels = append(levels, fmt.Sprintf("%d %s %d", ability.Tick, ability.Name, ability.Level))
+		return nil
+	}
+	parser.OnAbilityCast = func(cast *AbilityCast) error {
+		casts = append(casts, fmt.Sprintf("%d %s %v", cast.Tick, cast.Ability, cast.Targets))
+		return nil
+	}
+
+	hero := g.hero(0, TeamRadiant, "npc_dota_hero_axe", Vector3{})
+	ability := func(slot, index int, name string) *PacketEntity {
+		entity := g.entity(index, "DT_DOTA_Ability", map[string]interface{}{
+			"DT_BaseEntity.m_iName":                 name,
+			"DT_DOTABaseAbility.m_iLevel":           0,
+			"DT_DOTABaseAbility.m_fCooldown":        0.0,
+			"DT_DOTABaseAbility.m_flCooldownLength": 0.0,
+			"DT_DOTABaseAbility.m_iManaCost":        0,
+		})
+		hero.Values[fmt.Sprintf("DT_DOTA_BaseNPC.m_hAbilities.%03d", slot)] = entity.Handle()
+		return entity
+	}
+	call := ability(0, 200, "axe_berserkers_call")
+	hunger := ability(1, 201, "axe_battle_hunger")
+	parser.processTick(10, nil)
+
+	call.Values["DT_DOTABaseAbility.m_iLevel"] = 1
+	parser.processTick(20, nil)
+	hunger.Values["DT_DOTABaseAbility.m_iLevel"] = 1
+	parser.processTick(30, nil)
+	call.Values["DT_DOTABaseAbility.m_iLevel"] = 2
+	parser.processTick(40, nil)
+
+	// a cast with two targets, and one of an ability without a cooldown.
+	call.Values["DT_DOTABaseAbility.m_fCooldown"] = 112.0
+	call.Values["DT_DOTABaseAbility.m_flCooldownLength"] = 12.0
+	call.Values["DT_DOTABaseAbility.m_iManaCost"] = 90
+	g.tick(50,
+		&CombatLogAbility{Attacker: "npc_dota_hero_axe", Ability: "axe_berserkers_call", Target: "npc_dota_creep_badguys_melee"},
+		&CombatLogAbility{Attacker: "npc_dota_hero_axe", Ability: "axe_berserkers_call", Target: "npc_dota_hero_bane"},
+		&CombatLogAbility{Attacker: "npc_dota_hero_bane", Ability: "axe_berserkers_call", Target: "npc_dota_hero_axe"},
+	)
+	g.tick(60, &CombatLogAbility{Attacker: "npc_dota_hero_axe", Ability: "axe_battle_hunger", Target: "npc_dota_hero_bane"})
+
+	assert.Equal([]string{
+		"20 axe_berserkers_call 1",
+		"30 axe_battle_hunger 1",
+		"40 axe_berserkers_call 2",
+	}, levels)
+	assert.Equal([]string{
+		"50 axe_berserkers_call [npc_dota_creep_badguys_melee npc_dota_hero_bane]",
+		"60 axe_battle_hunger [npc_dota_hero_bane]",
+	}, casts)
+	assert.Len(book.SkillBuild(hero.Handle()), 3)
+	assert.Empty(book.logs)
+
+	// an ability gained at level 3 levels up from there, and a cast without a
+	// cooldown goes to the hero that logged it.
+	bane := g.hero(5, TeamDire, "npc_dota_hero_bane", Vector3{})
+	stolen := g.entity(210, "DT_DOTA_Ability", map[string]interface{}{
+		"DT_BaseEntity.m_iName":       "axe_battle_hunger",
+		"DT_DOTABaseAbility.m_iLevel": 3,
+	})
+	bane.Values["DT_DOTA_BaseNPC.m_hAbilities.000"] = stolen.Handle()
+	g.tick(70, &CombatLogAbility{Attacker: "npc_dota_hero_bane", Ability: "axe_battle_hunger", Target: "npc_dota_hero_axe"})
+	stolen.Values["DT_DOTABaseAbility.m_iLevel"] = 4
+	parser.processTick(80, nil)
+	assert.Equal("80 axe_battle_hunger 4", levels[len(levels)-1])
+	assert.Len(levels, 4)
+	if assert.Len(book.Casts, 3) {
+		assert.Equal(bane.Handle(), book.Casts[2].Hero)
+		assert.Equal(3, book.Casts[2].Level)
+	}
+
+	abilities := book.Hero(hero.Handle())
+	if assert.Len(abilities, 2) {
+		assert.Equal(&AbilityState{
+			Handle:         call.Handle(),
+			Hero:           hero.Handle(),
+			Name:           "axe_berserkers_call",
+			Level:          2,
+			Cooldown:       112,
+			CooldownLength: 12,
+			ManaCost:       90,
+		}, abilities[0])
+		assert.Equal(2*time.Second, abilities[0].Remaining(110))
+		assert.Equal(time.Duration(0), abilities[0].Remaining(120))
+		assert.Equal("axe_battle_hunger", abilities[1].Name)
+	}
+}
+
+// TestAbilitiesReplay follows the abilities of a hero through the entity
+// packets and the combat log of a replay.
+func TestAbilitiesReplay(t *testing.T) {
+	assert := assert.New(t)
+
+	r := newTestReplay(t)
+	r.at(1)
+	ability := func(index int, name string) int {
+		return r.create(index, "DT_DOTA_Ability", map[string]interface{}{
+			"DT_BaseEntity.m_iName":                 name,
+			"DT_DOTABaseAbility.m_iLevel":           0,
+			"DT_DOTABaseAbility.m_fCooldown":        0.0,
+			"DT_DOTABaseAbility.m_flCooldownLength": 0.0,
+			"DT_DOTABaseAbility.m_iManaCost":        0,
+		})
+	}
+	axe := r.create(100, "DT_DOTA_Unit_Hero", map[string]interface{}{
+		"DT_DOTA_BaseNPC.m_iszUnitName":    "npc_dota_hero_axe",
+		"DT_DOTA_BaseNPC.m_hAbilities.000": ability(200, "axe_berserkers_call"),
+		"DT_DOTA_BaseNPC.m_hAbilities.001": ability(201, "axe_battle_hunger"),
+	})
+	r.create(1, "DT_DOTA_PlayerResource", map[string]interface{}{
+		"DT_DOTA_PlayerResource.m_iPlayerTeams.0000":  TeamRadiant,
+		"DT_DOTA_PlayerResource.m_hSelectedHero.0000": axe,
+	})
+
+	r.at(2)
+	r.update(200, map[string]interface{}{"DT_DOTABaseAbility.m_iLevel": 1})
+	r.at(3)
+	r.update(201, map[string]interface{}{"DT_DOTABaseAbility.m_iLevel": 1})
+	r.at(4)
+	r.update(200, map[string]interface{}{
+		"DT_DOTABaseAbility.m_fCooldown":        16.0,
+		"DT_DOTABaseAbility.m_flCooldownLength": 12.0,
+		"DT_DOTABaseAbility.m_iManaCost":        80,
+	})
+	r.log(&CombatLogAbility{Attacker: "npc_dota_hero_axe", Ability: "axe_berserkers_call", Target: "npc_dota_hero_bane", AttackerIsHero: true})
+
+	parser := r.parser()
+	book := parser.TrackAbilities()
+	var levels, casts []string
+	parser.OnAbilityLevelUp = func(ability *AbilityTracker) error {
+		levels = append(levels, fmt.Sprintf("%d %s %d", ability.Tick, ability.Name, ability.Level))
+		return nil
+	}
+	parser.OnAbilityCast = func(cast *AbilityCast) error {
+		casts = append(casts, fmt.Sprintf("%d %s %d %v", cast.Tick, cast.Ability, cast.Level, cast.Targets))
+		return nil
+	}
+	assert.NoError(parser.Parse())
+
+	assert.Equal([]string{"2 axe_berserkers_call 1", "3 axe_battle_hunger 1"}, levels)
+	assert.Equal([]string{"4 axe_berserkers_call 1 [npc_dota_hero_bane]"}, casts)
+	if assert.Len(book.Casts, 1) {
+		assert.Equal(axe, book.Casts[0].Hero)
+	}
+	abilities := book.Hero(axe)
+	if assert.Len(abilities, 2) {
+		assert.Equal(16.0, abilities[0].Cooldown)
+		assert.Equal(80, abilities[0].ManaCost)
+		assert.Equal(4*time.Second, abilities[0].Remaining(12))
+	}
+}
+
+func TestKillFeed(t *testing.T) {
+	assert := assert.New(t)
+
+	g := newTestGame()
+	parser := g.parser
+	feed := parser.TrackKills(DefaultKillRules)
+	var kills []*Kill
+	parser.OnKill = func(kill *Kill) error {
+		kills = append(kills, kill)
+		return nil
+	}
+
+	g.hero(0, TeamRadiant, "npc_dota_hero_axe", Vector3{X: 10, Y: 276})
+	g.hero(1, TeamRadiant, "npc_dota_hero_omniknight", Vector3{})
+	g.hero(5, TeamDire, "npc_dota_hero_bane", Vector3{})
+	g.hero(6, TeamDire, "npc_dota_hero_lina", Vector3{})
+	g.hero(7, TeamDire, "npc_dota_hero_crystal_maiden", Vector3{})
+	g.hero(8, TeamDire, "npc_dota_hero_sniper", Vector3{})
+
+	damage := func(attacker, target string, time float32) *CombatLogDamage {
+		return &CombatLogDamage{Attacker: attacker, Target: target, Time: time, AttackerIsHero: true, TargetIsHero: true}
+	}
+	g.tick(100,
+		damage("npc_dota_hero_sniper", "npc_dota_hero_axe", 80),
+		damage("npc_dota_hero_lina", "npc_dota_hero_axe", 100),
+		damage("npc_dota_hero_omniknight", "npc_dota_hero_axe", 101),
+		&CombatLogModifierAdd{Attacker: "npc_dota_hero_bane", Target: "npc_dota_hero_axe", IsDebuff: true, Time: 105, AttackerIsHero: true, TargetIsHero: true},
+		&CombatLogHeal{Attacker: "npc_dota_hero_crystal_maiden", Target: "npc_dota_hero_bane", Time: 108, AttackerIsHero: true, TargetIsHero: true},
+		&CombatLogDeath{Attacker: "npc_dota_hero_bane", Target: "npc_dota_hero_axe", Cause: "bane_fiends_grip", Time: 110, AttackerIsHero: true, TargetIsHero: true},
+		&CombatLogGold{Target: "npc_dota_hero_bane", Value: 300, Reason: goldReasonHeroKill},
+		&CombatLogGold{Target: "npc_dota_hero_lina", Value: 100, Reason: goldReasonHeroKill},
+		&CombatLogGold{Target: "npc_dota_hero_lina", Value: 40, Reason: 13},
+	)
+
+	// a tower kill, and an illusion dying doesn't count.
+	g.tick(200,
+		damage("npc_dota_hero_omniknight", "npc_dota_hero_lina", 118),
+		&CombatLogDeath{Attacker: "npc_dota_hero_omniknight", Target: "npc_dota_hero_bane", Time: 119, TargetIsHero: true, TargetIsIllusion: true},
+		&CombatLogDeath{Attacker: "npc_dota_goodguys_tower1_mid", Target: "npc_dota_hero_lina", Cause: "dota_unknown", Time: 120, TargetIsHero: true},
+	)
+
+	assert.Equal(feed.Kills, kills)
+	if assert.Len(kills, 2) {
+		assert.Equal(&Kill{
+			Tick:       100,
+			Victim:     "npc_dota_hero_axe",
+			Killer:     "npc_dota_hero_bane",
+			Assisters:  []string{"npc_dota_hero_lina", "npc_dota_hero_crystal_maiden"},
+			Ability:    "bane_fiends_grip",
+			GoldBounty: 400,
+			Position:   Vector3{X: 10, Y: 276},
+		}, kills[0])
+		assert.Equal("npc_dota_goodguys_tower1_mid", kills[1].Killer)
+		assert.Equal([]string{"npc_dota_hero_omniknight"}, kills[1].Assisters)
+		assert.Equal(0, kills[1].GoldBounty)
+	}
+
+	// without debuffs and heals only lina assists.
+	feed.Rules = KillRules{AssistWindow: 20 * time.Second}
+	g.tick(300,
+		damage("npc_dota_hero_lina", "npc_dota_hero_axe", 200),
+		&CombatLogModifierAdd{Attacker: "npc_dota_hero_sniper", Target: "npc_dota_hero_axe", IsDebuff: true, Time: 205, AttackerIsHero: true, TargetIsHero: true},
+		&CombatLogHeal{Attacker: "npc_dota_hero_crystal_maiden", Target: "npc_dota_hero_bane", Time: 208, AttackerIsHero: true, TargetIsHero: true},
+		&CombatLogDeath{Attacker: "npc_dota_hero_bane", Target: "npc_dota_hero_axe", Time: 210, TargetIsHero: true},
+	)
+	if assert.Len(kills, 3) {
+		assert.Equal([]string{"npc_dota_hero_lina"}, kills[2].Assisters)
+	}
+
+	// a double kill counts the bounties once.
+	g.tick(400,
+		&CombatLogDeath{Attacker: "npc_dota_hero_lina", Target: "npc_dota_hero_axe", Time: 310, AttackerIsHero: true, TargetIsHero: true},
+		&CombatLogDeath{Attacker: "npc_dota_hero_lina", Target: "npc_dota_hero_omniknight", Time: 310, AttackerIsHero: true, TargetIsHero: true},
+		&CombatLogGold{Target: "npc_dota_hero_lina", Value: 300, Reason: goldReasonHeroKill},
+		&CombatLogGold{Target: "npc_dota_hero_lina", Value: 250, Reason: goldReasonHeroKill},
+	)
+	if assert.Len(kills, 5) {
+		assert.Equal(550, kills[3].GoldBounty+kills[4].GoldBounty)
+	}
+}
+
+// TestKillFeedReplay finds a kill in the combat log of a replay, with the
+// position of the hero from its entity.
+func TestKillFeedReplay(t *testing.T) {
+	assert := assert.New(t)
+
+	r := newTestReplay(t)
+	r.at(1)
+	resource := map[string]interface{}{}
+	for slot, name := range []string{"npc_dota_hero_axe", "npc_dota_hero_bane", "npc_dota_hero_lina"} {
+		hero := map[string]interface{}{"DT_DOTA_BaseNPC.m_iszUnitName": name}
+		setTestPosition(hero, "DT_DOTA_BaseNPC", Vector3{X: 10, Y: 276})
+		team := TeamDire
+		if slot == 0 {
+			team = TeamRadiant
+		}
+		resource[fmt.Sprintf("DT_DOTA_PlayerResource.m_iPlayerTeams.%04d", slot)] = team
+		resource[fmt.Sprintf("DT_DOTA_PlayerResource.m_hSelectedHero.%04d", slot)] = r.create(100+slot, "DT_DOTA_Unit_Hero", hero)
+	}
+	r.create(1, "DT_DOTA_PlayerResource", resource)
+
+	r.at(2)
+	moved := map[string]interface{}{}
+	setTestPosition(moved, "DT_DOTA_BaseNPC", Vector3{X: -500, Y: 1000})
+	r.update(100, moved)
+	r.log(&CombatLogDamage{Attacker: "npc_dota_hero_lina", Target: "npc_dota_hero_axe", Value: 300, Time: 100, AttackerIsHero: true, TargetIsHero: true})
+	r.log(&CombatLogDeath{Attacker: "npc_dota_hero_bane", Target: "npc_dota_hero_axe", Cause: "bane_fiends_grip", Time: 110, AttackerIsHero: true, TargetIsHero: true})
+	r.log(&CombatLogGold{Target: "npc_dota_hero_bane", Value: 300, Reason: goldReasonHeroKill})
+	r.log(&CombatLogGold{Target: "npc_dota_hero_lina", Value: 100, Reason: goldReasonHeroKill})
+	r.at(3)
+
+	parser := r.parser()
+	feed := parser.TrackKills(DefaultKillRules)
+	assert.NoError(parser.Parse())
+
+	if assert.Len(feed.Kills, 1) {
+		assert.Equal(&Kill{
+			Tick:       2,
+			Victim:     "npc_dota_hero_axe",
+			Killer:     "npc_dota_hero_bane",
+			Assisters:  []string{"npc_dota_hero_lina"},
+			Ability:    "bane_fiends_grip",
+			GoldBounty: 400,
+			Position:   Vector3{X: -500, Y: 1000},
+		}, feed.Kills[0])
+	}
+}
+
+func TestTeamfights(t *testing.T) {
+	assert := assert.New(t)
+
+	g := newTestGame()
+	parser := g.parser
+	parser.Clock().TickInterval = 1
+	teamfights := parser.TrackTeamfights(TeamfightRules{Cooldown: 10 * time.Second, MinDeaths: 1, Radius: 500})
+	var fights []*Teamfight
+	parser.OnTeamfight = func(fight *Teamfight) error {
+		fights = append(fights, fight)
+		return nil
+	}
+
+	far := Vector3{X: 9000, Y: 276}
+	g.hero(0, TeamRadiant, "npc_dota_hero_axe", Vector3{X: 10, Y: 276})
+	g.hero(1, TeamRadiant, "npc_dota_hero_omniknight", Vector3{X: 100, Y: 276})
+	g.hero(5, TeamDire, "npc_dota_hero_sniper", far)
+	g.hero(6, TeamDire, "npc_dota_hero_lina", far)
+
+	damage := func(attacker, target string, value int) *CombatLogDamage {
+		return &CombatLogDamage{Attacker: attacker, Target: target, Value: value, AttackerIsHero: true, TargetIsHero: true}
+	}
+	tick := func(tick int, logs ...CombatLogEntry) {
+		assert.NoError(g.tick(tick, logs...))
+	}
+
+	tick(5, &CombatLogAbility{Attacker: "npc_dota_hero_lina", Ability: "lina_light_strike_array", AttackerIsHero: true})
+	tick(10,
+		&CombatLogAbility{Attacker: "npc_dota_hero_lina", Ability: "lina_dragon_slave", AttackerIsHero: true},
+		damage("npc_dota_hero_lina", "npc_dota_hero_axe", 100),
+	)
+	tick(12,
+		&CombatLogItem{User: "npc_dota_hero_axe", Item: "item_blade_mail", UserIsHero: true},
+		damage("npc_dota_hero_axe", "npc_dota_hero_lina", 50),
+		&CombatLogDamage{Attacker: "npc_dota_creep_badguys_melee", Target: "npc_dota_hero_axe", Value: 20, TargetIsHero: true},
+		damage("npc_dota_hero_omniknight", "npc_dota_hero_axe", 10),
+	)
+	tick(15,
+		&CombatLogDeath{Attacker: "npc_dota_hero_lina", Target: "npc_dota_hero_axe", AttackerIsHero: true, TargetIsHero: true},
+		&CombatLogGold{Target: "npc_dota_hero_lina", Value: 200},
+		&CombatLogXP{Target: "npc_dota_hero_lina", Value: 150},
+	)
+	tick(24)
+	assert.Empty(fights)
+	tick(25)
+
+	if assert.Len(fights, 1) {
+		fight := fights[0]
+		assert.Equal(10, fight.StartTick)
+		assert.Equal(15, fight.EndTick)
+		assert.Equal([]TeamfightDeath{{Tick: 15, Victim: "npc_dota_hero_axe", Killer: "npc_dota_hero_lina", Position: Vector3{X: 10, Y: 276}}}, fight.Deaths)
+		assert.Equal(-200, fight.GoldSwing)
+		assert.Equal(-150, fight.XPSwing)
+		if assert.Len(fight.Players, 3) {
+			axe, omniknight, lina := fight.Players[0], fight.Players[1], fight.Players[2]
+			assert.Equal("npc_dota_hero_axe", axe.Hero)
+			assert.Equal(50, axe.DamageDealt)
+			assert.Equal(100, axe.DamageTaken)
+			assert.Equal(1, axe.Deaths)
+			assert.Equal(map[string]int{"item_blade_mail": 1}, axe.Items)
+			assert.Equal("npc_dota_hero_omniknight", omniknight.Hero)
+			assert.Equal(0, omniknight.DamageDealt)
+			assert.Equal(6, lina.Slot)
+			assert.Equal(TeamDire, lina.Team)
+			assert.Equal(100, lina.DamageDealt)
+			assert.Equal(50, lina.DamageTaken)
+			assert.Equal(map[string]int{"lina_dragon_slave": 1}, lina.Abilities)
+			assert.Equal(200, lina.Gold)
+		}
+	}
+
+	// not enough deaths.
+	tick(40, damage("npc_dota_hero_sniper", "npc_dota_hero_axe", 30))
+	tick(60)
+	// still going on when the replay ends.
+	tick(70, &CombatLogDeath{Attacker: "npc_dota_hero_sniper", Target: "npc_dota_hero_omniknight", AttackerIsHero: true, TargetIsHero: true})
+	assert.NoError(teamfights.close(parser))
+	assert.Len(fights, 2)
+	assert.Equal(teamfights.Fights, fights)
+
+	// pauses don't count towards the cooldown.
+	clock := parser.Clock()
+	clock.startTime, clock.HornTick = 1, 0
+	tick(100, &CombatLogDeath{Attacker: "npc_dota_hero_sniper", Target: "npc_dota_hero_axe", AttackerIsHero: true, TargetIsHero: true})
+	clock.setPaused(101, true)
+	tick(150)
+	clock.setPaused(200, false)
+	tick(208)
+	assert.Len(fights, 2)
+	tick(209)
+	if assert.Len(fights, 3) {
+		assert.Equal(100, fights[2].EndTick)
+	}
+}
+
+// TestTeamfightsReplay finds a fight in the combat log of a replay, the heroes
+// near the death are where their entities moved to.
+func TestTeamfightsReplay(t *testing.T) {
+	assert := assert.New(t)
+
+	far := Vector3{X: 9000, Y: 276}
+	r := newTestReplay(t)
+	r.at(1)
+	resource := map[string]interface{}{}
+	for slot, player := range []struct {
+		team int
+		hero string
+		pos  Vector3
+	}{
+		{TeamRadiant, "npc_dota_hero_axe", Vector3{X: 10, Y: 276}},
+		{TeamRadiant, "npc_dota_hero_omniknight", far},
+		{TeamDire, "npc_dota_hero_lina", far},
+		{TeamDire, "npc_dota_hero_sniper", far},
+	} {
+		hero := map[string]interface{}{"DT_DOTA_BaseNPC.m_iszUnitName": player.hero}
+		setTestPosition(hero, "DT_DOTA_BaseNPC", player.pos)
+		resource[fmt.Sprintf("DT_DOTA_PlayerResource.m_iPlayerTeams.%04d", slot)] = player.team
+		resource[fmt.Sprintf("DT_DOTA_PlayerResource.m_hSelectedHero.%04d", slot)] = r.create(100+slot, "DT_DOTA_Unit_Hero", hero)
+	}
+	r.create(1, "DT_DOTA_PlayerResource", resource)
+
+	r.at(10)
+	r.log(&CombatLogAbility{Attacker: "npc_dota_hero_lina", Ability: "lina_dragon_slave", AttackerIsHero: true})
+	r.log(&CombatLogDamage{Attacker: "npc_dota_hero_lina", Target: "npc_dota_hero_axe", Value: 100, AttackerIsHero: true, TargetIsHero: true})
+	r.at(12)
+	r.log(&CombatLogDamage{Attacker: "npc_dota_hero_axe", Target: "npc_dota_hero_lina", Value: 50, AttackerIsHero: true, TargetIsHero: true})
+	r.at(14)
+	moved := map[string]interface{}{}
+	setTestPosition(moved, "DT_DOTA_BaseNPC", Vector3{X: 300, Y: 276})
+	r.update(103, moved)
+	r.at(15)
+	r.log(&CombatLogDeath{Attacker: "npc_dota_hero_lina", Target: "npc_dota_hero_axe", AttackerIsHero: true, TargetIsHero: true})
+	r.log(&CombatLogGold{Target: "npc_dota_hero_lina", Value: 200})
+	r.at(24)
+	r.at(25)
+
+	parser := r.parser()
+	teamfights := parser.TrackTeamfights(TeamfightRules{Cooldown: 10 * time.Second, MinDeaths: 1, Radius: 500})
+	var ended []int
+	parser.OnTeamfight = func(fight *Teamfight) error {
+		ended = append(ended, parser.tick)
+		return nil
+	}
+	assert.NoError(parser.Parse())
+
+	assert.Equal([]int{25}, ended)
+	if assert.Len(teamfights.Fights, 1) {
+		fight := teamfights.Fights[0]
+		assert.Equal(10, fight.StartTick)
+		assert.Equal(15, fight.EndTick)
+		assert.Equal([]TeamfightDeath{{Tick: 15, Victim: "npc_dota_hero_axe", Killer: "npc_dota_hero_lina", Position: Vector3{X: 10, Y: 276}}}, fight.Deaths)
+		assert.Equal(-200, fight.GoldSwing)
+		var heroes []string
+		for _, player := range fight.Players {
+			heroes = append(heroes, fmt.Sprintf("%d %s %d %d", player.Slot, player.Hero, player.DamageDealt, player.DamageTaken))
+		}
+		assert.Equal([]string{
+			"0 npc_dota_hero_axe 50 100",
+			"2 npc_dota_hero_lina 100 50",
+			"3 npc_dota_hero_sniper 0 0",
+		}, heroes)
+	}
+}
+
+func TestWards(t *testing.T) {
+	assert := assert.New(t)
+
+	g := newTestGame()
+	parser := g.parser
+	parser.Clock().TickInterval = 1
+	wards := parser.TrackWards()
+	var placed, removed []*Ward
+	parser.OnWardPlaced = func(ward *Ward) error {
+		placed = append(placed, ward)
+		return nil
+	}
+	parser.OnWardRemoved = func(ward *Ward) error {
+		removed = append(removed, ward)
+		return nil
+	}
+
+	axe := g.hero(0, TeamRadiant, "npc_dota_hero_axe", Vector3{})
+	g.hero(5, TeamDire, "npc_dota_hero_bane", Vector3{})
+	ward := func(tick, index, team int, class string, owner int) *PacketEntity {
+		values := map[string]interface{}{
+			"DT_BaseEntity.m_iTeamNum":     team,
+			"DT_BaseEntity.m_hOwnerEntity": owner,
+		}
+		setTestPosition(values, "DT_BaseEntity", Vector3{X: 10, Y: 276})
+		pe := g.entity(index, class, values)
+		assert.NoError(parser.wards.created(parser, tick, pe))
+		return pe
+	}
+	observer := ward(10, 200, TeamRadiant, "DT_DOTA_NPC_Observer_Ward", axe.Handle())
+	sentry := ward(10, 201, TeamRadiant, "DT_DOTA_NPC_Observer_Ward_TrueSight", invalidHandle)
+	ward(10, 202, TeamRadiant, "DT_DOTA_BaseNPC_Creep_Lane", invalidHandle)
+	parser.processTick(10, nil)
+
+	assert.Equal(wards.Wards, placed)
+	assert.Equal(placed, wards.Active())
+	if assert.Len(placed, 2) {
+		assert.Equal(&Ward{
+			Handle:     observer.Handle(),
+			Type:       WardObserver,
+			Team:       TeamRadiant,
+			Placer:     "npc_dota_hero_axe",
+			PlacerSlot: 0,
+			Position:   Vector3{X: 10, Y: 276},
+			PlacedTick: 10,
+		}, placed[0])
+		assert.Equal(WardSentry, placed[1].Type)
+		assert.Equal(-1, placed[1].PlacerSlot)
+		assert.Equal("", placed[1].Placer)
+	}
+
+	// dewarded, the entity goes away a tick after the death.
+	g.tick(20, &CombatLogDeath{Attacker: "npc_dota_hero_bane", Target: "npc_dota_observer_wards"})
+	assert.Empty(removed)
+	assert.NoError(parser.entityDelete(observer, 21))
+	parser.processTick(21, nil)
+
+	// the death of another sentry is too long ago for this one.
+	g.tick(30, &CombatLogDeath{Attacker: "npc_dota_hero_bane", Target: "npc_dota_sentry_wards"})
+	assert.NoError(parser.entityDelete(sentry, 40))
+	parser.processTick(40, nil)
+
+	// wards of both teams dying at once, each killed by an enemy.
+	radiant := ward(41, 203, TeamRadiant, "DT_DOTA_NPC_Observer_Ward", invalidHandle)
+	dire := ward(41, 204, TeamDire, "DT_DOTA_NPC_Observer_Ward", invalidHandle)
+	g.tick(50,
+		&CombatLogDeath{Attacker: "npc_dota_hero_axe", Target: "npc_dota_observer_wards"},
+		&CombatLogDeath{Attacker: "npc_dota_hero_bane", Target: "npc_dota_observer_wards"},
+	)
+	assert.NoError(parser.entityDelete(radiant, 51))
+	assert.NoError(parser.entityDelete(dire, 51))
+	parser.processTick(51, nil)
+
+	// denied by an ally.
+	denied := ward(52, 205, TeamRadiant, "DT_DOTA_NPC_Observer_Ward_TrueSight", invalidHandle)
+	g.tick(60, &CombatLogDeath{Attacker: "npc_dota_hero_axe", Target: "npc_dota_sentry_wards"})
+	assert.NoError(parser.entityDelete(denied, 60))
+	parser.processTick(60, nil)
+
+	if assert.Len(removed, 5) {
+		assert.Equal(21, removed[0].RemovedTick)
+		assert.Equal("npc_dota_hero_bane", removed[0].Dewarder)
+		assert.Equal(40, removed[1].RemovedTick)
+		assert.Equal("", removed[1].Dewarder)
+		assert.Equal("npc_dota_hero_bane", removed[2].Dewarder)
+		assert.Equal(TeamDire, removed[3].Team)
+		assert.Equal("npc_dota_hero_axe", removed[3].Dewarder)
+		assert.Equal("", removed[4].Dewarder)
+		assert.Equal("npc_dota_hero_axe", removed[4].Denier)
+	}
+	assert.Empty(wards.Active())
+
+	// without a tick interval the window is as long as with the default one.
+	parser.Clock().TickInterval = 0
+	late := ward(61, 206, TeamRadiant, "DT_DOTA_NPC_Observer_Ward", invalidHandle)
+	g.tick(70, &CombatLogDeath{Attacker: "npc_dota_hero_bane", Target: "npc_dota_observer_wards"})
+	assert.NoError(parser.entityDelete(late, 71))
+	parser.processTick(71, nil)
+	if assert.Len(removed, 6) {
+		assert.Equal("npc_dota_hero_bane", removed[5].Dewarder)
+	}
+}
+
+// TestWardsReplay follows a ward from the entity packets of a replay, it's
+// removed by the deletions at the end of a delta.
+func TestWardsReplay(t *testing.T) {
+	assert := assert.New(t)
+
+	props := []*SendProp{{DtName: "DT_BaseEntity", VarName: "m_iTeamNum", Type: DPT_Int, NumBits: 8, Flags: SPROP_UNSIGNED}}
+	nameProps(props)
+
+	w := &bitWriter{}
+	w.writeEntityIndex(5)
+	w.writeBool(false)
+	w.writeBool(true)
+	w.writeBits(1, 4)
+	w.writeBits(1, 10)
+	w.writeBool(true)
+	w.writeBool(false)
+	w.writeVarInt(16383)
+	w.writeBits(TeamDire, 8)
+	create := w.packet(1)
+
+	w = &bitWriter{}
+	w.writeBool(true)
+	w.writeBits(5, 11)
+	w.writeBool(false)
+	deletion := w.packet(0)
+	deletion.IsDelta = proto.Bool(true)
+
+	frames := []testFrame{
+		{dota.EDemoCommands_DEM_FileHeader, 0, &dota.CDemoFileHeader{}},
+		{dota.EDemoCommands_DEM_SyncTick, 0, &dota.CDemoSyncTick{}},
+		{dota.EDemoCommands_DEM_Packet, 1, &dota.CDemoPacket{Data: buildPacket(t, int(dota.SVC_Messages_svc_PacketEntities), create)}},
+		{dota.EDemoCommands_DEM_Packet, 2, &dota.CDemoPacket{Data: buildPacket(t, int(dota.NET_Messages_net_Tick), &dota.CNETMsg_Tick{Tick: proto.Uint32(2)})}},
+		{dota.EDemoCommands_DEM_Packet, 3, &dota.CDemoPacket{Data: buildPacket(t, int(dota.SVC_Messages_svc_PacketEntities), deletion)}},
+		{dota.EDemoCommands_DEM_Packet, 4, &dota.CDemoPacket{Data: buildPacket(t, int(dota.NET_Messages_net_Tick), &dota.CNETMsg_Tick{Tick: proto.Uint32(4)})}},
+	}
+	parser, err := NewParser(buildReplay(t, frames...))
+	if !assert.NoError(err) {
+		return
+	}
+	parser.init()
+	parser.ClassIdNumBits = 4
+	parser.ClassInfosNameMapping[1] = "DT_DOTA_NPC_Observer_Ward"
+	parser.decoders[1] = newClassDecoder(props)
+	parser.PropNames[1] = propNames(props)
+
+	wards := parser.TrackWards()
+	var events []string
+	parser.OnWardPlaced = func(ward *Ward) error {
+		events = append(events, fmt.Sprintf("placed %d %s %d", ward.PlacedTick, ward.Type, ward.Team))
+		return nil
+	}
+	parser.OnWardRemoved = func(ward *Ward) error {
+		events = append(events, fmt.Sprintf("removed %d %q", ward.RemovedTick, ward.Dewarder))
+		return nil
+	}
+	assert.NoError(parser.Parse())
+
+	assert.Equal([]string{"placed 1 observer 3", `removed 3 ""`}, events)
+	assert.Empty(wards.Active())
+	assert.Nil(parser.Entities[5])
+}
+
+func TestGameClock(t *testing.T) {
+	assert := assert.New(t)
+
+	frames := []testFrame{
+		{dota.EDemoCommands_DEM_FileHeader, 0, &dota.CDemoFileHeader{}},
+		{dota.EDemoCommands_DEM_SyncTick, 0, &dota.CDemoSyncTick{}},
+	}
+	for tick := 1; tick <= 20; tick++ {
+		var data []byte
+		switch tick {
+		case 5, 12:
+			data = buildPacket(t, int(dota.SVC_Messages_svc_SetPause), &dota.CSVCMsg_SetPause{Paused: proto.Bool(true)})
+		case 8, 15:
+			data = buildPacket(t, int(dota.SVC_Messages_svc_SetPause), &dota.CSVCMsg_SetPause{Paused: proto.Bool(false)})
+		default:
+			data = buildPacket(t, int(dota.NET_Messages_net_Tick), &dota.CNETMsg_Tick{Tick: proto.Uint32(uint32(tick))})
+		}
+		frames = append(frames, testFrame{dota.EDemoCommands_DEM_Packet, tick, &dota.CDemoPacket{Data: data}})
+	}
+	parser, err := NewParser(buildReplay(t, frames...))
+	if !assert.NoError(err) {
+		return
+	}
+	events := []string{}
+	parser.OnPause = func(tick int) error {
+		events = append(events, fmt.Sprintf("pause %d", tick))
+		return nil
+	}
+	parser.OnUnpause = func(tick int) error {
+		events = append(events, fmt.Sprintf("unpause %d", tick))
+		return nil
+	}
+	assert.NoError(parser.Parse())
+	assert.Equal([]string{"pause 5", "unpause 8", "pause 12", "unpause 15"}, events)
+
+	clock := parser.Clock()
+	assert.False(clock.Paused(4))
+	assert.True(clock.Paused(5))
+	assert.True(clock.Paused(7))
+	assert.False(clock.Paused(8))
+	_, ok := clock.TickToGameTime(10)
+	assert.False(ok)
+
+	// the horn was at tick 3, the game rules say so at tick 10, after the first
+	// pause. The game rules pausing too doesn't count twice.
+	clock.TickInterval = 0.5
+	parser.gameRules = &PacketEntity{Values: map[string]interface{}{
+		"m_fGameTime":       102.0,
+		"m_flGameStartTime": 100.0,
+		"m_bGamePaused":     0,
+	}}
+	assert.NoError(parser.updateClock(10))
+	assert.Equal(3, clock.HornTick)
+	for tick, expected := range map[int]time.Duration{
+		1:  -time.Second,
+		3:  0,
+		5:  time.Second,
+		7:  time.Second,
+		8:  time.Second,
+		10: 2 * time.Second,
+		20: 5500 * time.Millisecond,
+	} {
+		actual, ok := clock.TickToGameTime(tick)
+		assert.True(ok)
+		assert.Equal(expected, actual, "tick %d", tick)
+	}
+	for expected, gameTime := range map[int]time.Duration{
+		1:  -time.Second,
+		5:  time.Second,
+		9:  1500 * time.Millisecond,
+		20: 5500 * time.Millisecond,
+	} {
+		actual, ok := clock.GameTimeToTick(gameTime)
+		assert.True(ok)
+		assert.Equal(expected, actual, "game time %s", gameTime)
+	}
+	gameTime, _ := clock.ServerTimeToGameTime(130.25)
+	assert.Equal(30250*time.Millisecond, gameTime)
+
+	parser.gameRules.Values["m_bGamePaused"] = 1
+	assert.NoError(parser.updateClock(25))
+	assert.Equal("pause 25", events[len(events)-1])
+	assert.True(clock.Paused(100))
+	_, ok = clock.GameTimeToTick(time.Minute)
+	assert.False(ok)
+
+	// going back from a pause that didn't end forgets the later pauses, and
+	// finds them again.
+	frames[len(frames)-1] = testFrame{dota.EDemoCommands_DEM_Packet, 20, &dota.CDemoPacket{
+		Data: buildPacket(t, int(dota.SVC_Messages_svc_SetPause), &dota.CSVCMsg_SetPause{Paused: proto.Bool(true)}),
+	}}
+	parser, _ = NewParser(buildReplay(t, frames...))
+	assert.NoError(parser.Parse())
+	assert.True(parser.Clock().Paused(20))
+	assert.NoError(parser.SeekToTick(3))
+	assert.False(parser.Clock().Paused(6))
+	assert.False(parser.Clock().Paused(20))
+	events = events[:0]
+	parser.OnPause = func(tick int) error {
+		events = append(events, fmt.Sprintf("pause %d", tick))
+		return nil
+	}
+	parser.OnUnpause = func(tick int) error {
+		events = append(events, fmt.Sprintf("unpause %d", tick))
+		return nil
+	}
+	assert.NoError(parser.Parse())
+	assert.Equal([]string{"pause 5", "unpause 8", "pause 12", "unpause 15", "pause 20"}, events)
+
+	// pauses are followed while seeking, without calling back.
+	events = events[:0]
+	assert.NoError(parser.SeekToTick(13))
+	assert.True(parser.Clock().Paused(6))
+	assert.True(parser.Clock().Paused(13))
+	assert.Empty(events)
+}
+
+// TestGameClockSeekForward skips a pause by seeking to a full packet after it,
+// the horn is found again from the game rules there.
+func TestGameClockSeekForward(t *testing.T) {
+	assert := assert.New(t)
+
+	props := []*SendProp{
+		{DtName: "DT_DOTAGamerules", VarName: "m_fGameTime", Type: DPT_Float, Flags: SPROP_NOSCALE},
+		{DtName: "DT_DOTAGamerules", VarName: "m_flGameStartTime", Type: DPT_Float, Flags: SPROP_NOSCALE},
+	}
+	nameProps(props)
+	rules := func(now float32) []byte {
+		w := &bitWriter{}
+		w.writeEntityIndex(3)
+		w.writeBool(false)
+		w.writeBool(true)
+		w.writeBits(1, 4)
+		w.writeBits(1, 10)
+		w.writeBool(true)
+		w.writeBool(true)
+		w.writeBool(false)
+		w.writeVarInt(16383)
+		w.writeBits(uint(math.Float32bits(now)), 32)
+		w.writeBits(uint(math.Float32bits(100)), 32)
+		return buildPacket(t, int(dota.SVC_Messages_svc_PacketEntities), w.packet(1))
+	}
+
+	// the horn is at tick -4, the game is paused from tick 4 to 7.
+	frames := []testFrame{
+		{dota.EDemoCommands_DEM_FileHeader, 0, &dota.CDemoFileHeader{}},
+		{dota.EDemoCommands_DEM_SyncTick, 0, &dota.CDemoSyncTick{}},
+		{dota.EDemoCommands_DEM_Packet, 1, &dota.CDemoPacket{Data: rules(105)}},
+	}
+	for tick := 2; tick <= 12; tick++ {
+		if tick == 10 {
+			frames = append(frames, testFrame{dota.EDemoCommands_DEM_FullPacket, tick, &dota.CDemoFullPacket{
+				StringTable: &dota.CDemoStringTables{},
+				Packet:      &dota.CDemoPacket{Data: rules(111)},
+			}})
 		}
+		var data []byte
+		switch tick {
+		case 4:
+			data = buildPacket(t, int(dota.SVC_Messages_svc_SetPause), &dota.CSVCMsg_SetPause{Paused: proto.Bool(true)})
+		case 7:
+			data = buildPacket(t, int(dota.SVC_Messages_svc_SetPause), &dota.CSVCMsg_SetPause{Paused: proto.Bool(false)})
+		default:
+			data = buildPacket(t, int(dota.NET_Messages_net_Tick), &dota.CNETMsg_Tick{Tick: proto.Uint32(uint32(tick))})
+		}
+		frames = append(frames, testFrame{dota.EDemoCommands_DEM_Packet, tick, &dota.CDemoPacket{Data: data}})
+	}
+	parser, err := NewParser(buildReplay(t, frames...))
+	if !assert.NoError(err) {
+		return
 	}
+	parser.init()
+	parser.ClassIdNumBits = 4
+	parser.ClassInfosNameMapping[1] = "DT_DOTAGamerulesProxy"
+	parser.decoders[1] = newClassDecoder(props)
+	parser.PropNames[1] = propNames(props)
+	parser.Clock().TickInterval = 1
+
+	assert.NoError(parser.SeekToTick(2))
+	assert.Equal(-4, parser.Clock().HornTick)
+	assert.NoError(parser.SeekToTick(11))
+	assert.False(parser.Clock().Paused(5))
+	gameTime, ok := parser.Clock().TickToGameTime(11)
+	assert.True(ok)
+	assert.Equal(12*time.Second, gameTime)
+	tick, ok := parser.Clock().GameTimeToTick(12 * time.Second)
+	assert.True(ok)
+	assert.Equal(11, tick)
+}
+
+func TestSeekToTick(t *testing.T) {
+	assert := assert.New(t)
 
-	parser.Parse()
+	frames := []testFrame{
+		{dota.EDemoCommands_DEM_FileHeader, 0, &dota.CDemoFileHeader{}},
+		{dota.EDemoCommands_DEM_SyncTick, 0, &dota.CDemoSyncTick{}},
+	}
+	for tick := 1; tick <= 10; tick++ {
+		if tick == 5 {
+			frames = append(frames, testFrame{dota.EDemoCommands_DEM_FullPacket, tick, &dota.CDemoFullPacket{
+				StringTable: &dota.CDemoStringTables{},
+				Packet:      &dota.CDemoPacket{},
+			}})
+		}
+		frames = append(frames, testFrame{dota.EDemoCommands_DEM_Packet, tick, &dota.CDemoPacket{
+			Data: buildPacket(t, int(dota.NET_Messages_net_Tick), &dota.CNETMsg_Tick{Tick: proto.Uint32(uint32(tick))}),
+		}})
+	}
+
+	parser, err := NewParser(buildReplay(t, frames...))
+	if !assert.NoError(err) {
+		return
+	}
+	ticks := []int{}
+	parser.OnTick = func(tick int, obj *dota.CNETMsg_Tick) error {
+		ticks = append(ticks, int(obj.GetTick()))
+		return nil
+	}
+
+	// forward, through the full packet at tick 5
+	assert.NoError(parser.SeekToTick(7))
+	assert.NoError(parser.Parse())
+	assert.Equal([]int{8, 9, 10}, ticks)
+	if assert.Len(parser.Parser.fullPackets, 1) {
+		assert.Equal(5, parser.Parser.fullPackets[0].tick)
+	}
+
+	// back to the full packet
+	ticks = ticks[:0]
+	assert.NoError(parser.SeekToTick(6))
+	assert.NoError(parser.Parse())
+	assert.Equal([]int{7, 8, 9, 10}, ticks)
+
+	// back before the first full packet, starting over
+	ticks = ticks[:0]
+	parser.playerResource = &PacketEntity{}
+	assert.NoError(parser.SeekToTick(2))
+	assert.Nil(parser.playerResource)
+	assert.NoError(parser.Parse())
+	assert.Equal([]int{3, 4, 5, 6, 7, 8, 9, 10}, ticks)
+
+	parser, _ = NewParserFromReader(iotest.OneByteReader(bytes.NewReader(buildReplay(t, frames...))))
+	assert.Equal(ErrNotSeekable, parser.SeekToTick(7))
+
+	// the file stays open after Parse, until Close.
+	path := filepath.Join(t.TempDir(), "seek.dem")
+	if !assert.NoError(ioutil.WriteFile(path, buildReplay(t, frames...), 0644)) {
+		return
+	}
+	parser, err = ParserFromFile(path)
+	if !assert.NoError(err) {
+		return
+	}
+	ticks = ticks[:0]
+	parser.OnTick = func(tick int, obj *dota.CNETMsg_Tick) error {
+		ticks = append(ticks, int(obj.GetTick()))
+		return nil
+	}
+	assert.NoError(parser.Parse())
+	ticks = ticks[:0]
+	assert.NoError(parser.SeekToTick(8))
+	assert.NoError(parser.Parse())
+	assert.Equal([]int{9, 10}, ticks)
+	assert.NoError(parser.Close())
+}
+
+func TestParseContext(t *testing.T) {
+	assert := assert.New(t)
+
+	frames := []testFrame{
+		{dota.EDemoCommands_DEM_FileHeader, 0, &dota.CDemoFileHeader{}},
+		{dota.EDemoCommands_DEM_SyncTick, 0, &dota.CDemoSyncTick{}},
+	}
+	for tick := 1; tick <= 10; tick++ {
+		frames = append(frames, testFrame{dota.EDemoCommands_DEM_Packet, tick, &dota.CDemoPacket{
+			Data: buildPacket(t, int(dota.NET_Messages_net_Tick), &dota.CNETMsg_Tick{Tick: proto.Uint32(uint32(tick))}),
+		}})
+	}
+	data := buildReplay(t, frames...)
+
+	// a fight going on when parsing stops still ends.
+	fighting := func(parser *Parser) *Teamfights {
+		teamfights := parser.TrackTeamfights(TeamfightRules{Cooldown: time.Minute})
+		teamfights.current = &Teamfight{StartTick: 1}
+		teamfights.players = map[string]*TeamfightPlayer{}
+		return teamfights
+	}
+
+	// stopping from a callback
+	parser, _ := NewParser(data)
+	teamfights := fighting(parser)
+	ticks := []int{}
+	parser.OnTick = func(tick int, obj *dota.CNETMsg_Tick) error {
+		ticks = append(ticks, tick)
+		if tick == 3 {
+			return ErrStopParsing
+		}
+		return nil
+	}
+	assert.NoError(parser.Parse())
+	assert.Equal([]int{1, 2, 3}, ticks)
+	assert.Len(teamfights.Fights, 1)
+
+	// any other error is passed through
+	failure := errors.New("failure")
+	parser, _ = NewParser(data)
+	parser.AfterTick = func(tick int) error {
+		if tick == 4 {
+			return failure
+		}
+		return nil
+	}
+	assert.Equal(failure, parser.Parse())
+
+	// cancellation
+	ctx, cancel := context.WithCancel(context.Background())
+	parser, _ = NewParser(data)
+	teamfights = fighting(parser)
+	ticks = ticks[:0]
+	parser.OnTick = func(tick int, obj *dota.CNETMsg_Tick) error {
+		ticks = append(ticks, tick)
+		if tick == 5 {
+			cancel()
+		}
+		return nil
+	}
+	assert.Equal(context.Canceled, parser.ParseContext(ctx))
+	assert.Equal([]int{1, 2, 3, 4, 5}, ticks)
+	assert.Len(teamfights.Fights, 1)
+}
+
+func TestHandle(t *testing.T) {
+	assert := assert.New(t)
+
+	frames := []testFrame{
+		{dota.EDemoCommands_DEM_FileHeader, 0, &dota.CDemoFileHeader{}},
+		{dota.EDemoCommands_DEM_SyncTick, 0, &dota.CDemoSyncTick{}},
+	}
+	for tick := 1; tick <= 3; tick++ {
+		frames = append(frames, testFrame{dota.EDemoCommands_DEM_Packet, tick, &dota.CDemoPacket{
+			Data: buildPacket(t, int(dota.NET_Messages_net_Tick), &dota.CNETMsg_Tick{Tick: proto.Uint32(uint32(tick))}),
+		}})
+	}
+
+	parser, _ := NewParser(buildReplay(t, frames...))
+	calls := []string{}
+	parser.OnTick = func(tick int, obj *dota.CNETMsg_Tick) error {
+		calls = append(calls, fmt.Sprint("field ", tick))
+		return nil
+	}
+	parser.OnMessage(&dota.CNETMsg_Tick{}, func(tick int, msg proto.Message) error {
+		calls = append(calls, fmt.Sprint("message ", msg.(*dota.CNETMsg_Tick).GetTick()))
+		return nil
+	})
+	assert.NoError(parser.Handle(func(tick int, obj *dota.CNETMsg_Tick) {
+		calls = append(calls, fmt.Sprint("handle ", obj.GetTick()))
+	}))
+	assert.NoError(parser.Handle(func(tick int, obj *dota.CDemoSyncTick) error {
+		calls = append(calls, "sync")
+		return nil
+	}))
+	assert.Equal(ErrBadHandler, parser.Handle(func(obj *dota.CNETMsg_Tick) {}))
+	assert.Equal(ErrBadHandler, parser.Handle(func(tick int, obj string) {}))
+	assert.Equal(ErrBadHandler, parser.Handle(func(tick int, obj proto.Message) {}))
+	type tickNumber int
+	assert.Equal(ErrBadHandler, parser.Handle(func(tick tickNumber, obj *dota.CNETMsg_Tick) {}))
+
+	assert.NoError(parser.Parse())
+	assert.Equal([]string{
+		"sync",
+		"field 1", "message 1", "handle 1",
+		"field 2", "message 2", "handle 2",
+		"field 3", "message 3", "handle 3",
+	}, calls)
+}
+
+func TestLogger(t *testing.T) {
+	assert := assert.New(t)
+
+	data := buildReplay(t,
+		testFrame{dota.EDemoCommands_DEM_FileHeader, 0, &dota.CDemoFileHeader{}},
+		testFrame{dota.EDemoCommands_DEM_SyncTick, 0, &dota.CDemoSyncTick{}},
+		testFrame{dota.EDemoCommands_DEM_Packet, 2, &dota.CDemoPacket{
+			Data: buildPacket(t, 200, &dota.CNETMsg_Tick{Tick: proto.Uint32(2)}),
+		}},
+	)
+
+	parser, _ := NewParser(data)
+	assert.NoError(parser.Parse())
+
+	diagnostics := []Diagnostic{}
+	parser, _ = NewParser(data)
+	parser.Logger = LoggerFunc(func(d Diagnostic) {
+		diagnostics = append(diagnostics, d)
+	})
+	assert.NoError(parser.Parse())
+	if assert.Len(diagnostics, 1) {
+		assert.Equal(SeverityWarning, diagnostics[0].Severity)
+		assert.Equal(2, diagnostics[0].Tick)
+		assert.Equal("NETSVC 200", diagnostics[0].MessageType)
+	}
+}
+
+func TestGameEvent(t *testing.T) {
+	assert := assert.New(t)
+
+	list := &dota.CSVCMsg_GameEventList{Descriptors: []*dota.CSVCMsg_GameEventListDescriptorT{{
+		Eventid: proto.Int32(7),
+		Name:    proto.String("hltv_status"),
+		Keys: []*dota.CSVCMsg_GameEventListKeyT{
+			{Type: proto.Int32(3), Name: proto.String("clients")},
+			{Type: proto.Int32(1), Name: proto.String("master")},
+		},
+	}}}
+	event := &dota.CSVCMsg_GameEvent{Eventid: proto.Int32(7), Keys: []*dota.CSVCMsg_GameEventKeyT{
+		{Type: proto.Int32(3), ValLong: proto.Int32(523)},
+		{Type: proto.Int32(1), ValString: proto.String("146.66.152.49:28027")},
+	}}
+
+	parser, _ := NewParser(buildReplay(t,
+		testFrame{dota.EDemoCommands_DEM_FileHeader, 0, &dota.CDemoFileHeader{}},
+		testFrame{dota.EDemoCommands_DEM_SyncTick, 0, &dota.CDemoSyncTick{}},
+		testFrame{dota.EDemoCommands_DEM_Packet, 1, &dota.CDemoPacket{
+			Data: buildPacket(t, int(dota.SVC_Messages_svc_GameEventList), list),
+		}},
+		testFrame{dota.EDemoCommands_DEM_Packet, 2, &dota.CDemoPacket{
+			Data: buildPacket(t, int(dota.SVC_Messages_svc_GameEvent), event),
+		}},
+	))
+
+	events := []*GameEvent{}
+	parser.OnGameEvent = func(tick int, event *GameEvent) error {
+		events = append(events, event)
+		return nil
+	}
+	named := 0
+	parser.OnGameEventNamed("hltv_status", func(tick int, event *GameEvent) error {
+		named++
+		return nil
+	})
+	parser.OnGameEventNamed("dota_chase_hero", func(tick int, event *GameEvent) error {
+		t.Error("unexpected dota_chase_hero")
+		return nil
+	})
+
+	assert.NoError(parser.Parse())
+	assert.Equal([]*GameEvent{{
+		Name:   "hltv_status",
+		Tick:   2,
+		Values: map[string]interface{}{"clients": int32(523), "master": "146.66.152.49:28027"},
+	}}, events)
+	assert.Equal(1, named)
+}
+
+func TestEntityLifecycle(t *testing.T) {
+	assert := assert.New(t)
+
+	parser, _ := NewParser(buildReplay(t))
+	parser.init()
+	parser.ClassIdNumBits = 4
+	parser.ClassInfosNameMapping[1] = "DT_Test"
+	parser.ClassInfosIdMapping["DT_Test"] = 1
+	parser.decoders[1] = newClassDecoder(nil)
+
+	events := []string{}
+	record := func(kind string) func(*PacketEntity) error {
+		return func(pe *PacketEntity) error {
+			events = append(events, fmt.Sprintf("%s %d/%d", kind, pe.Index, pe.SerialNum))
+			return nil
+		}
+	}
+	parser.OnEntityCreated = record("created")
+	parser.OnEntityLeft = record("left")
+	parser.OnEntityDeleted = record("deleted")
+
+	create := func(w *bitWriter, serial uint) {
+		w.writeBool(false)
+		w.writeBool(true)
+		w.writeBits(1, 4)
+		w.writeBits(serial, 10)
+		w.writeBool(false)
+		w.writeVarInt(16383)
+	}
+
+	w := &bitWriter{}
+	w.writeEntityIndex(5)
+	create(w, 1)
+	w.writeEntityIndex(0)
+	create(w, 2)
+	assert.NoError(parser.ParsePacket(1, w.packet(2)))
+	assert.True(parser.Entities[5].Active)
+	assert.True(parser.Entities[6].Active)
+
+	w = &bitWriter{}
+	w.writeEntityIndex(5)
+	w.writeBool(true) // leave
+	w.writeBool(false)
+	w.writeEntityIndex(0)
+	w.writeBool(true) // delete
+	w.writeBool(true)
+	assert.NoError(parser.ParsePacket(2, w.packet(2)))
+	left := parser.Entities[5]
+	assert.False(left.Active)
+	assert.Equal(left, parser.ByHandle[left.Handle()])
+	assert.Nil(parser.Entities[6])
+	assert.Len(parser.ByHandle, 1)
+
+	// a new entity in the same slot replaces the one that left
+	w = &bitWriter{}
+	w.writeEntityIndex(5)
+	create(w, 3)
+	assert.NoError(parser.ParsePacket(3, w.packet(1)))
+	assert.Nil(parser.ByHandle[left.Handle()])
+	assert.Equal(3, parser.Entities[5].SerialNum)
+	assert.Len(parser.ByHandle, 1)
+
+	// deltas delete entities that left after the updated ones, 6 is gone
+	// already.
+	w = &bitWriter{}
+	w.writeEntityIndex(5)
+	w.writeBool(true) // leave
+	w.writeBool(false)
+	w.writeBool(true)
+	w.writeBits(5, 11)
+	w.writeBool(true)
+	w.writeBits(6, 11)
+	w.writeBool(false)
+	msg := w.packet(1)
+	msg.IsDelta = proto.Bool(true)
+	assert.NoError(parser.ParsePacket(4, msg))
+	assert.Nil(parser.Entities[5])
+	assert.Empty(parser.ByHandle)
+
+	assert.Equal([]string{
+		"created 5/1", "created 6/2",
+		"left 5/1", "deleted 6/2",
+		"deleted 5/1", "created 5/3",
+		"left 5/3", "deleted 5/3",
+	}, events)
+}
+
+func TestEntityDelta(t *testing.T) {
+	assert := assert.New(t)
+
+	props := []*SendProp{{DtName: "DT_Test", VarName: "m_iHealth", Type: DPT_Int, NumBits: 8, Flags: SPROP_UNSIGNED}}
+	nameProps(props)
+	parser, _ := NewParser(buildReplay(t))
+	parser.init()
+	parser.ClassIdNumBits = 4
+	parser.ClassInfosNameMapping[1] = "DT_Test"
+	parser.decoders[1] = newClassDecoder(props)
+
+	w := &bitWriter{}
+	w.writeEntityIndex(5)
+	w.writeBool(false)
+	w.writeBool(true)
+	w.writeBits(1, 4)
+	w.writeBits(1, 10)
+	w.writeBool(true)
+	w.writeBool(false)
+	w.writeVarInt(16383)
+	w.writeBits(100, 8)
+	assert.NoError(parser.ParsePacket(1, w.packet(1)))
+
+	var deltas, oldDeltas []map[string]interface{}
+	parser.OnEntityPreserved = func(pe *PacketEntity) error {
+		deltas = append(deltas, pe.Delta)
+		oldDeltas = append(oldDeltas, pe.OldDelta)
+		return nil
+	}
+	preserve := func(tick int, health uint) {
+		w := &bitWriter{}
+		w.writeEntityIndex(5)
+		w.writeBool(false)
+		w.writeBool(false)
+		w.writeBool(true)
+		w.writeBool(false)
+		w.writeVarInt(16383)
+		w.writeBits(health, 8)
+		assert.NoError(parser.ParsePacket(tick, w.packet(1)))
+	}
+	preserve(2, 90)
+	preserve(3, 80)
+	assert.Equal([]map[string]interface{}{{"DT_Test.m_iHealth": 90}, {"DT_Test.m_iHealth": 80}}, deltas)
+	assert.Equal([]map[string]interface{}{{"DT_Test.m_iHealth": 100}, {"DT_Test.m_iHealth": 90}}, oldDeltas)
+
+	// borrowed maps are only valid during the callback.
+	parser.BorrowMessages = true
+	deltas = deltas[:0]
+	preserve(4, 70)
+	preserve(5, 60)
+	assert.Equal([]map[string]interface{}{{"DT_Test.m_iHealth": 60}, {"DT_Test.m_iHealth": 60}}, deltas)
+}
+
+func TestEntityAccessors(t *testing.T) {
+	assert := assert.New(t)
+
+	npc := []scopeEntry{{table: "DT_DOTA_BaseNPC_Hero"}, {table: "DT_DOTA_BaseNPC", varName: "baseclass"}}
+	base := append(npc[:2:2], scopeEntry{table: "DT_BaseEntity", varName: "baseclass"})
+	props := []*SendProp{
+		{DtName: "DT_DOTA_BaseNPC", VarName: "m_vecOrigin", scope: npc},
+		{DtName: "DT_BaseEntity", VarName: "m_vecOrigin", scope: base},
+		{DtName: "DT_DOTA_BaseNPC", VarName: "m_iHealth", scope: npc},
+		{DtName: "DT_DOTA_BaseNPC", VarName: "m_flMana", scope: npc},
+		{DtName: "DT_DOTA_BaseNPC", VarName: "m_iszUnitName", scope: npc},
+		{DtName: "DT_BaseEntity", VarName: "m_hOwnerEntity", scope: base},
+		{DtName: "DT_BaseEntity", VarName: "m_hEffectEntity", scope: base},
+	}
+	nameProps(props)
+	pe := &PacketEntity{
+		Values: map[string]interface{}{
+			"DT_BaseEntity.m_vecOrigin":     &Vector3{X: 1, Y: 2, Z: 3},
+			"DT_DOTA_BaseNPC.m_vecOrigin":   &Vector2{X: 4, Y: 5},
+			"DT_DOTA_BaseNPC.m_iHealth":     560,
+			"DT_DOTA_BaseNPC.m_flMana":      291.5,
+			"DT_DOTA_BaseNPC.m_iszUnitName": "npc_dota_hero_axe",
+			"DT_BaseEntity.m_hOwnerEntity":  1<<11 | 42,
+			"DT_BaseEntity.m_hEffectEntity": 0x1FFFFF,
+		},
+		names: propNames(props),
+	}
+
+	health, ok := pe.GetInt("m_iHealth")
+	assert.True(ok)
+	assert.Equal(560, health)
+	mana, ok := pe.GetFloat("m_flMana")
+	assert.True(ok)
+	assert.Equal(291.5, mana)
+	name, ok := pe.GetString("m_iszUnitName")
+	assert.True(ok)
+	assert.Equal("npc_dota_hero_axe", name)
+	owner, ok := pe.GetHandle("m_hOwnerEntity")
+	assert.True(ok)
+	assert.Equal(1<<11|42, owner)
+	_, ok = pe.GetHandle("m_hEffectEntity")
+	assert.False(ok)
+
+	// names declared twice are those of the most derived table
+	origin, ok := pe.GetVector3("m_vecOrigin")
+	assert.True(ok)
+	assert.Equal(Vector3{X: 4, Y: 5}, origin)
+	origin, ok = pe.GetVector3("DT_BaseEntity.m_vecOrigin")
+	assert.True(ok)
+	assert.Equal(Vector3{X: 1, Y: 2, Z: 3}, origin)
+
+	// wrong types don't panic
+	_, ok = pe.GetInt("m_flMana")
+	assert.False(ok)
+	_, ok = pe.GetFloat("m_iszUnitName")
+	assert.False(ok)
+	_, ok = pe.GetString("m_missing")
+	assert.False(ok)
+}
+
+func TestEntityPosition(t *testing.T) {
+	assert := assert.New(t)
+
+	pe := &PacketEntity{
+		Type: Preserve,
+		Values: map[string]interface{}{
+			"DT_BaseEntity.m_cellbits":    7,
+			"DT_BaseEntity.m_cellX":       100,
+			"DT_BaseEntity.m_cellY":       120,
+			"DT_BaseEntity.m_cellZ":       128,
+			"DT_BaseEntity.m_vecOrigin":   &Vector3{X: 1, Y: 2, Z: 3},
+			"DT_DOTA_BaseNPC.m_cellX":     130,
+			"DT_DOTA_BaseNPC.m_cellY":     140,
+			"DT_DOTA_BaseNPC.m_vecOrigin": &Vector2{X: 10, Y: 20},
+		},
+		Delta: map[string]interface{}{
+			"DT_DOTA_BaseNPC.m_cellX":     130,
+			"DT_DOTA_BaseNPC.m_vecOrigin": &Vector2{X: 10, Y: 20},
+		},
+		OldDelta: map[string]interface{}{
+			"DT_DOTA_BaseNPC.m_cellX":     129,
+			"DT_DOTA_BaseNPC.m_vecOrigin": &Vector2{X: 100, Y: 20},
+		},
+		names: map[string]string{
+			"m_cellbits":  "DT_BaseEntity.m_cellbits",
+			"m_cellX":     "DT_DOTA_BaseNPC.m_cellX",
+			"m_cellY":     "DT_DOTA_BaseNPC.m_cellY",
+			"m_cellZ":     "DT_BaseEntity.m_cellZ",
+			"m_vecOrigin": "DT_DOTA_BaseNPC.m_vecOrigin",
+		},
+	}
+
+	pos, ok := pe.Position()
+	assert.True(ok)
+	assert.Equal(Vector3{X: 130*128 - 16384 + 10, Y: 140*128 - 16384 + 20}, pos)
+
+	delta, ok := pe.PositionDelta()
+	assert.True(ok)
+	assert.Equal(Vector3{X: 128 - 90}, delta)
+
+	// entities that aren't units only have the DT_BaseEntity props
+	pe.names = map[string]string{
+		"m_cellbits":  "DT_BaseEntity.m_cellbits",
+		"m_cellX":     "DT_BaseEntity.m_cellX",
+		"m_cellY":     "DT_BaseEntity.m_cellY",
+		"m_cellZ":     "DT_BaseEntity.m_cellZ",
+		"m_vecOrigin": "DT_BaseEntity.m_vecOrigin",
+	}
+	pos, ok = pe.Position()
+	assert.True(ok)
+	assert.Equal(Vector3{X: 100*128 - 16384 + 1, Y: 120*128 - 16384 + 2, Z: 3}, pos)
+	_, ok = pe.PositionDelta()
+	assert.False(ok)
+
+	_, ok = (&PacketEntity{Values: map[string]interface{}{}}).Position()
+	assert.False(ok)
+}
+
+func TestSendTableNames(t *testing.T) {
+	assert := assert.New(t)
+
+	prop := func(varName string, kind DPTType, dtName string) *dota.CSVCMsg_SendTableSendpropT {
+		return &dota.CSVCMsg_SendTableSendpropT{
+			Type:    proto.Int32(int32(kind)),
+			VarName: proto.String(varName),
+			DtName:  proto.String(dtName),
+			Flags:   proto.Int32(0),
+			NumBits: proto.Int32(8),
+		}
+	}
+	sth := NewSendTablesHelper()
+	for _, table := range []*dota.CSVCMsg_SendTable{
+		{NetTableName: proto.String("DT_Base"), Props: []*dota.CSVCMsg_SendTableSendpropT{
+			prop("m_iHealth", DPT_Int, ""),
+		}},
+		{NetTableName: proto.String("DT_Pair"), Props: []*dota.CSVCMsg_SendTableSendpropT{
+			prop("m_a", DPT_Int, ""),
+		}},
+		{NetTableName: proto.String("m_iKills"), Props: []*dota.CSVCMsg_SendTableSendpropT{
+			prop("000", DPT_Int, ""),
+			prop("001", DPT_Int, ""),
+		}},
+		{NetTableName: proto.String("DT_Root"), Props: []*dota.CSVCMsg_SendTableSendpropT{
+			prop("baseclass", DPT_DataTable, "DT_Base"),
+			prop("m_iKills", DPT_DataTable, "m_iKills"),
+			prop("m_first", DPT_DataTable, "DT_Pair"),
+			prop("m_second", DPT_DataTable, "DT_Pair"),
+			prop("m_b", DPT_Int, ""),
+			prop("m_b", DPT_Int, ""),
+		}},
+	} {
+		sth.SetSendTable(table.GetNetTableName(), table)
+	}
+
+	names := []string{}
+	for _, prop := range sth.LoadSendTable("DT_Root") {
+		names = append(names, prop.Name)
+	}
+	assert.ElementsMatch([]string{
+		"DT_Base.m_iHealth",
+		"DT_Root.m_iKills.000",
+		"DT_Root.m_iKills.001",
+		"DT_Root.m_first.m_a",
+		"DT_Root.m_second.m_a",
+		"DT_Root.m_b-5",
+		"DT_Root.m_b-6",
+	}, names)
+}
+
+func TestArrayProps(t *testing.T) {
+	assert := assert.New(t)
+
+	sth := NewSendTablesHelper()
+	sth.SetSendTable("DT_Root", &dota.CSVCMsg_SendTable{
+		NetTableName: proto.String("DT_Root"),
+		Props: []*dota.CSVCMsg_SendTableSendpropT{{
+			Type:    proto.Int32(int32(DPT_Int)),
+			VarName: proto.String("m_hItems_element"),
+			Flags:   proto.Int32(int32(SPROP_INSIDEARRAY | SPROP_UNSIGNED)),
+			NumBits: proto.Int32(8),
+		}, {
+			Type:        proto.Int32(int32(DPT_Array)),
+			VarName:     proto.String("m_hItems"),
+			NumElements: proto.Int32(10),
+		}},
+	})
+	props := sth.LoadSendTable("DT_Root")
+	if !assert.Len(props, 1) {
+		return
+	}
+	assert.Equal("DT_Root.m_hItems", props[0].Name)
+
+	w := &bitWriter{}
+	w.writeBits(3, 4)
+	for _, item := range []uint{7, 8, 9} {
+		w.writeBits(item, 8)
+	}
+	values := NewBitReader(append(w.buf, 0, 0, 0, 0)).ReadPropertiesValues(props, []int{0})
+	assert.Equal(map[string]interface{}{"DT_Root.m_hItems": []interface{}{7, 8, 9}}, values)
+}
+
+// decoderTestProps covers all the ways props are decoded.
+func decoderTestProps() []*SendProp {
+	props := []*SendProp{
+		{VarName: "int", Type: DPT_Int, NumBits: 7},
+		{VarName: "uint", Type: DPT_Int, NumBits: 12, Flags: SPROP_UNSIGNED},
+		{VarName: "tick", Type: DPT_Int, NumBits: 32, Flags: SPROP_ENCODED_AGAINST_TICKCOUNT},
+		{VarName: "int64", Type: DPT_Int64, NumBits: 64, Flags: SPROP_UNSIGNED},
+		{VarName: "float", Type: DPT_Float, NumBits: 10, LowValue: -5, HighValue: 100},
+		{VarName: "coord", Type: DPT_Float, Flags: SPROP_COORD},
+		{VarName: "cell", Type: DPT_Float, NumBits: 10, Flags: SPROP_CELL_COORD},
+		{VarName: "noscale", Type: DPT_Float, Flags: SPROP_NOSCALE},
+		{VarName: "vector", Type: DPT_Vector, NumBits: 12, LowValue: 0, HighValue: 1024},
+		{VarName: "normal", Type: DPT_Vector, Flags: SPROP_NORMAL},
+		{VarName: "vectorxy", Type: DPT_VectorXY, Flags: SPROP_COORD},
+		{VarName: "string", Type: DPT_String},
+		{VarName: "array", Type: DPT_Array, NumElements: 6, Element: &SendProp{Type: DPT_Int, NumBits: 5}},
+	}
+	for _, prop := range props {
+		prop.DtName = "DT_Test"
+	}
+	nameProps(props)
+	return props
+}
+
+func TestReadNormalVector(t *testing.T) {
+	assert := assert.New(t)
+
+	prop := &SendProp{Type: DPT_Vector, Flags: SPROP_NORMAL}
+	decode := compileVector(prop)
+	w := &bitWriter{}
+	// (0, 0) points up, or down with the sign bit.
+	for _, sign := range []bool{false, true} {
+		w.writeBool(false)
+		w.writeBits(0, NormalFractionalBits)
+		w.writeBool(false)
+		w.writeBits(0, NormalFractionalBits)
+		w.writeBool(sign)
+	}
+	// (1, 1) is longer than a normal, Z is 0 instead of NaN.
+	w.writeBool(false)
+	w.writeBits(1<<NormalFractionalBits-1, NormalFractionalBits)
+	w.writeBool(false)
+	w.writeBits(1<<NormalFractionalBits-1, NormalFractionalBits)
+	w.writeBool(false)
+	data := append(w.buf, 0, 0, 0, 0)
+
+	expected := []*Vector3{{Z: 1}, {Z: -1}, {X: 1, Y: 1}}
+	br := NewBitReader(data)
+	for _, v := range expected {
+		assert.Equal(v, br.ReadVector(prop))
+	}
+	br = NewBitReader(data)
+	for _, v := range expected {
+		assert.Equal(v, decode(br))
+	}
+}
+
+func TestClassDecoder(t *testing.T) {
+	assert := assert.New(t)
+
+	props := decoderTestProps()
+	indices := make([]int, len(props))
+	for i := range indices {
+		indices[i] = i
+	}
+	decoder := newClassDecoder(props)
+
+	data := make([]byte, 4096)
+	for seed := int64(0); seed < 20; seed++ {
+		rand.New(rand.NewSource(seed)).Read(data)
+
+		expected := NewBitReader(data).ReadPropertiesValues(props, indices)
+
+		br := NewBitReader(data)
+		actual := map[string]interface{}{}
+		for _, i := range indices {
+			actual[decoder.keys[i]] = decoder.decode[i](br)
+		}
+		assert.Equal(expected, actual, "seed %d", seed)
+	}
+}
+
+func BenchmarkDecodeProps(b *testing.B) {
+	props := decoderTestProps()
+	indices := make([]int, len(props))
+	for i := range indices {
+		indices[i] = i
+	}
+	data := make([]byte, 4096)
+	rand.New(rand.NewSource(1)).Read(data)
+
+	b.Run("ReadPropertiesValues", func(b *testing.B) {
+		for n := 0; n < b.N; n++ {
+			NewBitReader(data).ReadPropertiesValues(props, indices)
+		}
+	})
+	b.Run("classDecoder", func(b *testing.B) {
+		decoder := newClassDecoder(props)
+		fields := make([]interface{}, len(props))
+		for n := 0; n < b.N; n++ {
+			br := NewBitReader(data)
+			for _, i := range indices {
+				fields[i] = decoder.decode[i](br)
+			}
+		}
+	})
+}
+
+func BenchmarkParse(b *testing.B) {
+	data, err := getReplayData(1405240741, "https://s3-us-west-2.amazonaws.com/yasha.dotabuff/1405240741.dem")
+	if err != nil {
+		b.Skipf("unable to get replay: %s", err)
+	}
+	b.SetBytes(int64(len(data)))
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	for n := 0; n < b.N; n++ {
+		parser, err := NewParser(data)
+		if err != nil {
+			b.Fatal(err)
+		}
+		parser.OnEntityPreserved = func(pe *PacketEntity) error { return nil }
+		if err := parser.Parse(); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func TestBorrowMessages(t *testing.T) {
+	assert := assert.New(t)
+
+	data := tickReplay(t, 10)
+	for _, borrow := range []bool{false, true} {
+		parser, err := NewParser(data)
+		if !assert.NoError(err) {
+			return
+		}
+		parser.BorrowMessages = borrow
+
+		var ticks []uint32
+		seen := map[*dota.CNETMsg_Tick]bool{}
+		parser.OnTick = func(tick int, obj *dota.CNETMsg_Tick) error {
+			ticks = append(ticks, obj.GetTick())
+			seen[obj] = true
+			return nil
+		}
+		assert.NoError(parser.Parse())
+		assert.Equal([]uint32{1, 2, 3, 4, 5, 6, 7, 8, 9, 10}, ticks, "borrow %v", borrow)
+		// a message is reused once its tick is processed, which is after the
+		// first message of the next tick was read.
+		if borrow {
+			assert.Len(seen, 2)
+		} else {
+			assert.Len(seen, 10)
+		}
+	}
+}
+
+func TestConcurrent(t *testing.T) {
+	assert := assert.New(t)
+
+	frames := []testFrame{
+		{dota.EDemoCommands_DEM_FileHeader, 0, &dota.CDemoFileHeader{}},
+		{dota.EDemoCommands_DEM_SyncTick, 0, &dota.CDemoSyncTick{}},
+	}
+	for tick := 1; tick <= 200; tick++ {
+		kind := int(dota.NET_Messages_net_Tick)
+		if tick%7 == 0 {
+			kind = 999
+		}
+		frames = append(frames, testFrame{dota.EDemoCommands_DEM_Packet, tick, &dota.CDemoPacket{
+			Data: buildPacket(t, kind, &dota.CNETMsg_Tick{Tick: proto.Uint32(uint32(tick))}),
+		}})
+	}
+	data := buildReplay(t, frames...)
+
+	run := func(data []byte, concurrent, borrow bool, stopAt int) ([]string, error) {
+		parser, err := NewParser(data)
+		if err != nil {
+			return nil, err
+		}
+		parser.Concurrent = concurrent
+		parser.BorrowMessages = borrow
+		var events []string
+		parser.Logger = LoggerFunc(func(d Diagnostic) {
+			events = append(events, d.String())
+		})
+		parser.BeforeTick = func(tick int) error {
+			events = append(events, fmt.Sprintf("before %d", tick))
+			return nil
+		}
+		parser.OnTick = func(tick int, obj *dota.CNETMsg_Tick) error {
+			events = append(events, fmt.Sprintf("tick %d %d", tick, obj.GetTick()))
+			if tick == stopAt {
+				return ErrStopParsing
+			}
+			return nil
+		}
+		err = parser.Parse()
+		return events, err
+	}
+
+	expected, err := run(data, false, false, 0)
+	assert.NoError(err)
+	assert.Contains(expected, "warning: tick 7: NETSVC 999: unknown message type, skipping 2 bytes")
+	for _, borrow := range []bool{false, true} {
+		actual, err := run(data, true, borrow, 0)
+		assert.NoError(err)
+		assert.Equal(expected, actual, "borrow %v", borrow)
+	}
+
+	expected, err = run(data, false, false, 50)
+	assert.NoError(err)
+	actual, err := run(data, true, false, 50)
+	assert.NoError(err)
+	assert.Equal(expected, actual)
+
+	truncated := data[:len(data)-3]
+	expected, expectedErr := run(truncated, false, false, 0)
+	actual, err = run(truncated, true, false, 0)
+	assert.True(errors.Is(err, ErrTruncated), "%v", err)
+	assert.Equal(expectedErr, err)
+	assert.Equal(expected, actual)
+
+	// a DEM_Packet claiming 1GB.
+	oversized := append(data[:len(data):len(data)], 0x07, 0xc9, 0x01, 0x80, 0x80, 0x80, 0x80, 0x04)
+	expected, expectedErr = run(oversized, false, false, 0)
+	actual, err = run(oversized, true, false, 0)
+	assert.True(errors.Is(err, ErrDecode), "%v", err)
+	assert.Equal(expectedErr, err)
+	assert.Equal(expected, actual)
+}
+
+func BenchmarkParseAllocs(b *testing.B) {
+	data := tickReplay(b, 1000)
+	for _, borrow := range []bool{false, true} {
+		b.Run(fmt.Sprintf("borrow=%v", borrow), func(b *testing.B) {
+			b.SetBytes(int64(len(data)))
+			b.ReportAllocs()
+			for n := 0; n < b.N; n++ {
+				parser, err := NewParser(data)
+				if err != nil {
+					b.Fatal(err)
+				}
+				parser.BorrowMessages = borrow
+				parser.OnTick = func(tick int, obj *dota.CNETMsg_Tick) error { return nil }
+				if err := parser.Parse(); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func TestBatchParser(t *testing.T) {
+	assert := assert.New(t)
+
+	replays := map[string][]byte{"corrupt": []byte("PBUFDEM")}
+	var paths []string
+	for i := 1; i <= 8; i++ {
+		path := fmt.Sprintf("replay%d", i)
+		replays[path] = tickReplay(t, i*10)
+		paths = append(paths, path)
+	}
+	paths = append(paths, "corrupt", "panic", "missing")
+	replays["panic"] = replays["replay1"]
+
+	for _, ordered := range []bool{true, false} {
+		var progress []int
+		b := &BatchParser{
+			Workers: 3,
+			Ordered: ordered,
+			Open: func(path string) (*Parser, error) {
+				data, ok := replays[path]
+				if !ok {
+					return nil, fmt.Errorf("no such replay: %s", path)
+				}
+				return NewParser(data)
+			},
+			Setup: func(path string, parser *Parser) interface{} {
+				ticks := new(int)
+				parser.OnTick = func(tick int, obj *dota.CNETMsg_Tick) error {
+					if path == "panic" {
+						panic("boom")
+					}
+					*ticks++
+					return nil
+				}
+				return ticks
+			},
+			OnProgress: func(done, total int, result BatchResult) {
+				assert.Equal(len(paths), total)
+				progress = append(progress, done)
+			},
+		}
+
+		results := make([]BatchResult, len(paths))
+		for n, result := range collectResults(b.Run(context.Background(), paths)) {
+			if ordered {
+				assert.Equal(n, result.Index)
+			}
+			results[result.Index] = result
+		}
+		assert.Equal([]int{1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11}, progress)
+
+		for i := 0; i < 8; i++ {
+			assert.NoError(results[i].Err)
+			assert.Equal(paths[i], results[i].Path)
+			assert.Equal((i+1)*10, *results[i].Value.(*int))
+		}
+		assert.Error(results[8].Err)
+		assert.True(errors.Is(results[9].Err, ErrPanic), "%v", results[9].Err)
+		assert.Error(results[10].Err)
+	}
+}
+
+func TestParseAllCanceled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	results := ParseAll(ctx, []string{"a.dem", "b.dem"}, 2, nil)
+	assert.Len(t, results, 2)
+	for _, result := range results {
+		assert.Equal(t, context.Canceled, result.Err)
+	}
+}
+
+func collectResults(results <-chan BatchResult) []BatchResult {
+	var all []BatchResult
+	for result := range results {
+		all = append(all, result)
+	}
+	return all
+}
+
+// testHeroClass is the class of the heroes of a testGame, its item and ability
+// slots are listed out of order.
+const testHeroClass = 1
+
+// testGame holds the entities the trackers follow, for tests that don't need
+// a whole replay.
+type testGame struct {
+	parser   *Parser
+	resource *PacketEntity
+}
+
+func newTestGame() *testGame {
+	parser := &Parser{}
+	parser.init()
+	keys := []string{"DT_DOTA_BaseNPC.m_iszUnitName", "DT_DOTA_BaseNPC.m_hAbilities.001", "DT_DOTA_BaseNPC.m_hAbilities.000"}
+	for i := 14; i >= 0; i-- {
+		keys = append(keys, fmt.Sprintf("DT_DOTA_UnitInventory.m_hItems.%03d", i))
+	}
+	parser.decoders[testHeroClass] = &classDecoder{keys: keys}
+
+	g := &testGame{parser: parser}
+	g.resource = g.entity(1, "DT_DOTA_PlayerResource", map[string]interface{}{})
+	return g
+}
+
+// entity adds an entity, its props can be read by their bare names, except
+// for elements of arrays.
+func (g *testGame) entity(index int, class string, values map[string]interface{}) *PacketEntity {
+	pe := &PacketEntity{Index: index, Name: class, Type: Create, Active: true, Values: values, names: map[string]string{}}
+	for key := range values {
+		name := key[strings.LastIndex(key, ".")+1:]
+		if _, err := strconv.Atoi(name); err != nil {
+			pe.names[name] = key
+		}
+	}
+	g.parser.Entities[index] = pe
+	g.parser.ByHandle[pe.Handle()] = pe
+	g.parser.trackEntity(pe)
+	return pe
+}
+
+// hero adds a player with a hero at pos, with empty item and ability slots.
+func (g *testGame) hero(slot, team int, name string, pos Vector3) *PacketEntity {
+	values := map[string]interface{}{"DT_DOTA_BaseNPC.m_iszUnitName": name}
+	for i := 0; i < 15; i++ {
+		values[fmt.Sprintf("DT_DOTA_UnitInventory.m_hItems.%03d", i)] = invalidHandle
+	}
+	for i := 0; i < 2; i++ {
+		values[fmt.Sprintf("DT_DOTA_BaseNPC.m_hAbilities.%03d", i)] = invalidHandle
+	}
+	setTestPosition(values, "DT_DOTA_BaseNPC", pos)
+	hero := g.entity(100+slot, "DT_DOTA_Unit_Hero", values)
+	hero.ClassId = testHeroClass
+	g.player(slot, team, hero.Handle())
+	return hero
+}
+
+// player sets the team and hero handle of a player in the player resource,
+// slots before it that aren't set yet are empty.
+func (g *testGame) player(slot, team, hero int) {
+	for i := 0; i <= slot; i++ {
+		if _, ok := g.resource.Values[fmt.Sprintf("DT_DOTA_PlayerResource.m_iPlayerTeams.%04d", i)]; !ok {
+			g.resource.Values[fmt.Sprintf("DT_DOTA_PlayerResource.m_iPlayerTeams.%04d", i)] = 0
+			g.resource.Values[fmt.Sprintf("DT_DOTA_PlayerResource.m_hSelectedHero.%04d", i)] = invalidHandle
+		}
+	}
+	g.resource.Values[fmt.Sprintf("DT_DOTA_PlayerResource.m_iPlayerTeams.%04d", slot)] = team
+	g.resource.Values[fmt.Sprintf("DT_DOTA_PlayerResource.m_hSelectedHero.%04d", slot)] = hero
+	g.parser.playerKeys = nil
+	g.parser.updatePlayers()
+}
+
+// tick passes the combat log entries to the trackers, and ends the tick.
+func (g *testGame) tick(tick int, logs ...CombatLogEntry) error {
+	for _, log := range logs {
+		g.parser.trackCombatLog(log)
+	}
+	return g.parser.processTick(tick, nil)
+}
+
+// setTestPosition sets the cell and origin props of table to pos, with cells
+// of 128 units.
+func setTestPosition(values map[string]interface{}, table string, pos Vector3) {
+	cellX, cellY := int(pos.X+maxCoordinate)/128, int(pos.Y+maxCoordinate)/128
+	values["DT_BaseEntity.m_cellbits"] = 7
+	values[table+".m_cellX"] = cellX
+	values[table+".m_cellY"] = cellY
+	values[table+".m_vecOrigin"] = &Vector2{
+		X: pos.X + maxCoordinate - float64(cellX*128),
+		Y: pos.Y + maxCoordinate - float64(cellY*128),
+	}
+}
+
+// tickReplay builds a replay with one net_Tick for each of the given ticks.
+func tickReplay(t testing.TB, ticks int) []byte {
+	frames := []testFrame{
+		{dota.EDemoCommands_DEM_FileHeader, 0, &dota.CDemoFileHeader{}},
+		{dota.EDemoCommands_DEM_SyncTick, 0, &dota.CDemoSyncTick{}},
+	}
+	for tick := 1; tick <= ticks; tick++ {
+		frames = append(frames, testFrame{dota.EDemoCommands_DEM_Packet, tick, &dota.CDemoPacket{
+			Data: buildPacket(t, int(dota.NET_Messages_net_Tick), &dota.CNETMsg_Tick{Tick: proto.Uint32(uint32(tick))}),
+		}})
+	}
+	return buildReplay(t, frames...)
+}
+
+// buildPacket writes the payload of a CDemoPacket containing one message.
+func buildPacket(t testing.TB, kind int, message proto.Message) []byte {
+	data, err := proto.Marshal(message)
+	if err != nil {
+		t.Fatal(err)
+	}
+	varint := make([]byte, binary.MaxVarintLen64)
+	buf := &bytes.Buffer{}
+	buf.Write(varint[:binary.PutUvarint(varint, uint64(kind))])
+	buf.Write(varint[:binary.PutUvarint(varint, uint64(len(data)))])
+	buf.Write(data)
+	return buf.Bytes()
+}
+
+// bitWriter is the counterpart of BitReader, to build entity data.
+type bitWriter struct {
+	buf []byte
+	pos int
+}
+
+func (w *bitWriter) writeBits(value uint, n int) {
+	for i := 0; i < n; i++ {
+		w.writeBool(value&(1<<uint(i)) != 0)
+	}
+}
+
+func (w *bitWriter) writeBool(b bool) {
+	if w.pos%8 == 0 {
+		w.buf = append(w.buf, 0)
+	}
+	if b {
+		w.buf[w.pos/8] |= 1 << uint(w.pos%8)
+	}
+	w.pos++
+}
+
+func (w *bitWriter) writeVarInt(value uint) {
+	for value >= 0x80 {
+		w.writeBits(value&0x7f|0x80, 8)
+		value >>= 7
+	}
+	w.writeBits(value, 8)
+}
+
+// writeEntityIndex writes the distance to the previous entity index.
+func (w *bitWriter) writeEntityIndex(delta uint) {
+	w.writeBits(delta&0xf, 4)
+	w.writeBool(delta > 0xf && delta <= 0xff)
+	w.writeBool(delta > 0xff)
+	if delta > 0xff {
+		w.writeBits(delta>>4, 8)
+	} else if delta > 0xf {
+		w.writeBits(delta>>4, 4)
+	}
+}
+
+func (w *bitWriter) packet(entries int32) *dota.CSVCMsg_PacketEntities {
+	return &dota.CSVCMsg_PacketEntities{
+		UpdatedEntries: proto.Int32(entries),
+		EntityData:     append(w.buf, 0, 0, 0, 0),
+	}
+}
+
+type testFrame struct {
+	command dota.EDemoCommands
+	tick    int
+	message proto.Message
+}
+
+// buildReplay writes a minimal replay containing the given frames.
+func buildReplay(t testing.TB, frames ...testFrame) []byte {
+	buf := bytes.NewBufferString(headerMagic + "\x00")
+	buf.Write(make([]byte, headerLength-buf.Len()))
+	varint := make([]byte, binary.MaxVarintLen64)
+	for _, frame := range frames {
+		data, err := proto.Marshal(frame.message)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, v := range []int{int(frame.command), frame.tick, len(data)} {
+			buf.Write(varint[:binary.PutUvarint(varint, uint64(v))])
+		}
+		buf.Write(data)
+	}
+	return buf.Bytes()
+}
+
+// testReplay builds a replay with entity packets and combat log entries, for
+// the trackers to follow like in a real one. A class has the props of the
+// first entity created with it, named by their keys.
+type testReplay struct {
+	t        testing.TB
+	frames   []testFrame
+	tick     int
+	data     []byte
+	classes  map[string]int
+	props    map[int][]*SendProp
+	entities map[int]int
+	names    []string
+}
+
+func newTestReplay(t testing.TB) *testReplay {
+	info := &dota.CSVCMsg_ServerInfo{MaxClasses: proto.Int32(12), TickInterval: proto.Float32(1)}
+	list := &dota.CSVCMsg_GameEventList{Descriptors: []*dota.CSVCMsg_GameEventListDescriptorT{{
+		Eventid: proto.Int32(1),
+		Name:    proto.String("dota_combatlog"),
+	}}}
+	return &testReplay{
+		t: t,
+		frames: []testFrame{
+			{dota.EDemoCommands_DEM_FileHeader, 0, &dota.CDemoFileHeader{}},
+			{dota.EDemoCommands_DEM_SignonPacket, 0, &dota.CDemoPacket{Data: append(
+				buildPacket(t, int(dota.SVC_Messages_svc_ServerInfo), info),
+				buildPacket(t, int(dota.SVC_Messages_svc_GameEventList), list)...,
+			)}},
+			{dota.EDemoCommands_DEM_SyncTick, 0, &dota.CDemoSyncTick{}},
+		},
+		classes:  map[string]int{},
+		props:    map[int][]*SendProp{},
+		entities: map[int]int{},
+	}
+}
+
+// at ends the current tick, what follows happens at tick.
+func (r *testReplay) at(tick int) {
+	r.flush()
+	r.tick = tick
+	r.data = buildPacket(r.t, int(dota.NET_Messages_net_Tick), &dota.CNETMsg_Tick{Tick: proto.Uint32(uint32(tick))})
+}
+
+func (r *testReplay) flush() {
+	if len(r.data) > 0 {
+		r.frames = append(r.frames, testFrame{dota.EDemoCommands_DEM_Packet, r.tick, &dota.CDemoPacket{Data: r.data}})
+		r.data = nil
+	}
+}
+
+// create adds an entity of class at index and returns its handle.
+func (r *testReplay) create(index int, class string, values map[string]interface{}) int {
+	id, ok := r.classes[class]
+	if !ok {
+		id = len(r.classes) + 1
+		r.classes[class] = id
+		r.props[id] = testProps(values)
+	}
+	r.entities[index] = id
+
+	w := &bitWriter{}
+	w.writeEntityIndex(uint(index))
+	w.writeBool(false)
+	w.writeBool(true)
+	w.writeBits(uint(id), 4)
+	w.writeBits(1, serialBits)
+	r.entity(w, index, values)
+	return index | 1<<indexBits
+}
+
+// update changes values of the entity at index.
+func (r *testReplay) update(index int, values map[string]interface{}) {
+	w := &bitWriter{}
+	w.writeEntityIndex(uint(index))
+	w.writeBool(false)
+	w.writeBool(false)
+	r.entity(w, index, values)
+}
+
+// remove deletes the entity at index.
+func (r *testReplay) remove(index int) {
+	w := &bitWriter{}
+	w.writeEntityIndex(uint(index))
+	w.writeBool(true)
+	w.writeBool(true)
+	r.data = append(r.data, buildPacket(r.t, int(dota.SVC_Messages_svc_PacketEntities), w.packet(1))...)
+}
+
+// entity writes values in the order of the props of the class of the entity
+// at index, and adds the packet.
+func (r *testReplay) entity(w *bitWriter, index int, values map[string]interface{}) {
+	props := r.props[r.entities[index]]
+	var indices []int
+	for i, prop := range props {
+		if _, ok := values[prop.Name]; ok {
+			indices = append(indices, i)
+		}
+	}
+	if len(indices) != len(values) {
+		r.t.Fatalf("unknown props for entity %d in %v", index, values)
+	}
+
+	last := -1
+	for _, i := range indices {
+		if i == last+1 {
+			w.writeBool(true)
+		} else {
+			w.writeBool(false)
+			w.writeVarInt(uint(i - last - 1))
+		}
+		last = i
+	}
+	w.writeBool(false)
+	w.writeVarInt(16383)
+
+	for _, i := range indices {
+		switch value := values[props[i].Name].(type) {
+		case int:
+			w.writeBits(uint(uint32(value)), 32)
+		case uint64:
+			w.writeBits(uint(value&0xffffffff), 32)
+			w.writeBits(uint(value>>32), 32)
+		case float64:
+			w.writeBits(uint(math.Float32bits(float32(value))), 32)
+		case *Vector2:
+			w.writeBits(uint(math.Float32bits(float32(value.X))), 32)
+			w.writeBits(uint(math.Float32bits(float32(value.Y))), 32)
+		case string:
+			w.writeBits(uint(len(value)), 9)
+			for _, b := range []byte(value) {
+				w.writeBits(uint(b), 8)
+			}
+		}
+	}
+	r.data = append(r.data, buildPacket(r.t, int(dota.SVC_Messages_svc_PacketEntities), w.packet(1))...)
+}
+
+// testProps returns a prop for each of the keys of values, like
+// "DT_BaseEntity.m_iTeamNum", of the type that decodes to its value.
+func testProps(values map[string]interface{}) []*SendProp {
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	props := make([]*SendProp, len(keys))
+	for i, key := range keys {
+		dot := strings.LastIndex(key, ".")
+		prop := &SendProp{DtName: key[:dot], VarName: key[dot+1:], Flags: SPROP_NOSCALE}
+		switch values[key].(type) {
+		case int:
+			prop.Type, prop.NumBits, prop.Flags = DPT_Int, 32, 0
+		case uint64:
+			prop.Type, prop.NumBits, prop.Flags = DPT_Int64, 64, SPROP_UNSIGNED
+		case float64:
+			prop.Type = DPT_Float
+		case *Vector2:
+			prop.Type = DPT_VectorXY
+		case string:
+			prop.Type = DPT_String
+		}
+		props[i] = prop
+	}
+	nameProps(props)
+	return props
+}
+
+// log adds a combat log entry, with its names in the CombatLogNames table.
+func (r *testReplay) log(entry CombatLogEntry) {
+	v := reflect.ValueOf(entry).Elem()
+	keys := []*dota.CSVCMsg_GameEventKeyT{{Type: proto.Int32(5), ValByte: proto.Int32(int32(entry.Type()))}}
+	for i := 0; i < v.NumField(); i++ {
+		index := atoi(v.Type().Field(i).Tag.Get("logIndex"))
+		if index <= 0 {
+			continue
+		}
+		for len(keys) <= index {
+			keys = append(keys, &dota.CSVCMsg_GameEventKeyT{Type: proto.Int32(6), ValBool: proto.Bool(false)})
+		}
+		key := &dota.CSVCMsg_GameEventKeyT{}
+		switch field := v.Field(i); field.Kind() {
+		case reflect.String:
+			key.Type, key.ValShort = proto.Int32(4), proto.Int32(int32(r.name(field.String())))
+		case reflect.Int:
+			key.Type, key.ValShort = proto.Int32(4), proto.Int32(int32(field.Int()))
+		case reflect.Float32:
+			key.Type, key.ValFloat = proto.Int32(2), proto.Float32(float32(field.Float()))
+		case reflect.Bool:
+			key.Type, key.ValBool = proto.Int32(6), proto.Bool(field.Bool())
+		}
+		keys[index] = key
+	}
+	event := &dota.CSVCMsg_GameEvent{Eventid: proto.Int32(1), Keys: keys}
+	r.data = append(r.data, buildPacket(r.t, int(dota.SVC_Messages_svc_GameEvent), event)...)
+}
+
+// name returns the index of name in the CombatLogNames table.
+func (r *testReplay) name(name string) int {
+	for i, existing := range r.names {
+		if existing == name {
+			return i
+		}
+	}
+	r.names = append(r.names, name)
+	return len(r.names) - 1
+}
+
+// parser ends the replay, and returns a parser for it that knows the classes
+// and names without send tables and string tables.
+func (r *testReplay) parser() *Parser {
+	r.flush()
+	frames := append(r.frames, testFrame{dota.EDemoCommands_DEM_Stop, r.tick + 1, &dota.CDemoStop{}})
+	parser, err := NewParser(buildReplay(r.t, frames...))
+	if err != nil {
+		r.t.Fatal(err)
+	}
+	parser.init()
+	for class, id := range r.classes {
+		parser.ClassInfosNameMapping[id] = class
+		parser.decoders[id] = newClassDecoder(r.props[id])
+		parser.PropNames[id] = propNames(r.props[id])
+	}
+	items := map[int]*StringTableItem{}
+	for i, name := range r.names {
+		items[i] = &StringTableItem{Str: name}
+	}
+	parser.Stsh.current[0] = &StringTable{Name: "CombatLogNames", Items: items}
+	return parser
+}
+
+func testReplayCase(t *testing.T, c *testCase) {
+	assert := assert.New(t)
+
+	data, err := getReplayData(c.matchId, c.url)
+	if err != nil {
+		t.Fatalf("unable to get replay: %s", err)
+	}
+
+	worldMins := &Vector3{}
+	worldMaxes := &Vector3{}
+	lastChatMessage := ""
+	heroKillCount := make(map[string]int)
+	heroDeathCount := make(map[string]int)
+
+	parser, err := NewParser(data)
+	if err != nil {
+		t.Fatalf("unable to create parser: %s", err)
+	}
+	parser.OnSayText2 = func(n int, o *dota.CUserMsg_SayText2) error {
+		lastChatMessage = o.GetText()
+		return nil
+	}
+
+	parser.OnChatEvent = func(n int, o *dota.CDOTAUserMsg_ChatEvent) error {
+		return nil
+	}
+
+	parser.OnCombatLog = func(tick int, entry CombatLogEntry) error {
+		switch log := entry.(type) {
+		case *CombatLogDeath:
+			if strings.HasPrefix(log.Target, "npc_dota_hero_") {
+				if _, ok := heroKillCount[log.Source]; !ok {
+					heroKillCount[log.Source] = 0
+				}
+				heroKillCount[log.Source] += 1
+			}
+
+			if _, ok := heroDeathCount[log.Target]; !ok {
+				heroDeathCount[log.Target] = 0
+			}
+			heroDeathCount[log.Target] += 1
+		}
+		return nil
+	}
+
+	parser.OnEntityCreated = func(ent *PacketEntity) error {
+		if ent.Tick == 0 && ent.Name == "DT_WORLD" {
+			worldMins = ent.Values["DT_WORLD.m_WorldMins"].(*Vector3)
+			worldMaxes = ent.Values["DT_WORLD.m_WorldMaxs"].(*Vector3)
+		}
+		return nil
+	}
+
+	if err := parser.Parse(); err != nil {
+		t.Fatalf("unable to parse replay: %s", err)
+	}
 
 	// Make sure we have found the death counts for specified heroes
 	if c.expectHeroDeathCount != nil {